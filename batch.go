@@ -0,0 +1,284 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/41north/async.go"
+	"github.com/juju/errors"
+)
+
+var (
+	ErrEmptyBatch = errors.ConstError("batch must contain at least one request")
+
+	// ErrMissingResponse is set as the error of any response within a batch for which the server did not
+	// return a corresponding element.
+	ErrMissingResponse = Error{
+		Code:    ErrInternal.Code,
+		Message: "no response received for request",
+	}
+)
+
+type BatchFuture = async.Future[async.Result[[]Response]]
+
+// batch records the ids of the requests that were sent together, allowing omitted responses to be detected
+// once the array reply has been received. Ids are held both in canonical form and as sent.
+type batch struct {
+	ids    []string
+	rawIds []json.RawMessage
+	// seq orders batches by when they were sent
+	seq uint64
+}
+
+func (c *client) SendBatch(ctx context.Context, reqs []Request) ([]Response, error) {
+	if timeout := c.opts.RequestTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// copy so that the ids assigned below are not visible to the caller
+	reqs = append([]Request(nil), reqs...)
+
+	ids := make([]json.RawMessage, len(reqs))
+	for i := range reqs {
+		// ids are assigned here so that the in flight entries can be cancelled
+		if err := reqs[i].EnsureIdWith(c.opts.IdStrategy); err != nil {
+			return nil, err
+		}
+		ids[i] = reqs[i].Id
+	}
+
+	future := c.SendBatchAsync(reqs)
+	select {
+	case <-ctx.Done():
+		for _, id := range ids {
+			c.cancel(id, ctx.Err())
+		}
+		return nil, ctx.Err()
+	case result := <-future.Get():
+		return result.Unwrap()
+	}
+}
+
+func (c *client) SendBatchAsync(reqs []Request) BatchFuture {
+	// create a future for returning the result
+	future := async.NewFuture[async.Result[[]Response]]()
+
+	if len(reqs) == 0 {
+		future.Set(async.NewResultErr[[]Response](ErrEmptyBatch))
+		return future
+	}
+
+	if c.stopped() {
+		// short circuit
+		future.Set(async.NewResultErr[[]Response](ErrClosed))
+		return future
+	}
+
+	b := &batch{
+		ids:    make([]string, len(reqs)),
+		rawIds: make([]json.RawMessage, len(reqs)),
+		seq:    c.batchSeq.Add(1),
+	}
+	elements := make([][]byte, len(reqs))
+	seen := make(map[string]bool, len(reqs))
+
+	for i := range reqs {
+		req := reqs[i]
+
+		// ensure a request id
+		if err := req.EnsureIdWith(c.opts.IdStrategy); err != nil {
+			future.Set(async.NewResultErr[[]Response](err))
+			return future
+		}
+
+		id := canonicalId(c.opts.Codec, req.Id)
+		if seen[id] {
+			future.Set(async.NewResultErr[[]Response](errors.Errorf("duplicate request id %s within batch", req.Id)))
+			return future
+		}
+		seen[id] = true
+
+		// marshal each element individually, they are joined into an array below
+		bytes, err := c.opts.Codec.Marshal(req)
+		if err != nil {
+			future.Set(async.NewResultErr[[]Response](errors.Annotate(err, "failed to marshal request to json")))
+			return future
+		}
+
+		b.ids[i] = id
+		b.rawIds[i] = req.Id
+		elements[i] = bytes
+	}
+
+	releases, err := c.acquireInFlight(len(reqs))
+	if err != nil {
+		future.Set(async.NewResultErr[[]Response](err))
+		return future
+	}
+
+	// create an in flight entry for each request in the batch
+	futures := make([]ResponseFuture, len(b.ids))
+	entries := make([]*inFlightRequest, len(b.ids))
+	for i, id := range b.ids {
+		futures[i] = async.NewFuture[async.Result[*Response]]()
+		entries[i] = &inFlightRequest{future: futures[i], data: elements[i], release: releases[i]}
+		if timeout := c.opts.RequestTimeout; timeout > 0 {
+			id := b.rawIds[i]
+			entries[i].timer = time.AfterFunc(timeout, func() {
+				c.cancel(id, context.DeadlineExceeded)
+			})
+		}
+		c.batches.Store(id, b)
+	}
+
+	data := make([]byte, 0, len(elements)*64)
+	data = append(data, '[')
+	data = append(data, bytes.Join(elements, []byte{','})...)
+	data = append(data, ']')
+
+	// send the batch
+	if err := c.write(b.ids, entries, data); err != nil {
+		for i, id := range b.ids {
+			c.batches.Delete(id)
+			entries[i].complete(async.NewResultErr[*Response](err))
+		}
+		future.Set(async.NewResultErr[[]Response](err))
+		return future
+	}
+
+	// gather the individual responses in the order the requests were provided
+	go func() {
+		resps := make([]Response, len(futures))
+		for i, f := range futures {
+			resp, err := (<-f.Get()).Unwrap()
+			if err != nil {
+				future.Set(async.NewResultErr[[]Response](err))
+				return
+			}
+			resps[i] = *resp
+		}
+		future.Set(async.NewResultValue[[]Response](resps))
+	}()
+
+	return future
+}
+
+// onBatchRejected handles an error response with a null id, which a server sends in place of an array when it
+// rejects a batch as a whole. The response cannot be correlated, so the earliest batch still awaiting
+// responses is failed with e. It reports whether there was such a batch. Only stream connections reach here,
+// as an AsyncConnection such as http reports the error against the message it was posted with.
+func (c *client) onBatchRejected(e Error) bool {
+	var oldest *batch
+	c.batches.Range(func(_, value any) bool {
+		if b := value.(*batch); oldest == nil || b.seq < oldest.seq {
+			oldest = b
+		}
+		return true
+	})
+
+	if oldest == nil {
+		return false
+	}
+
+	for _, id := range oldest.ids {
+		if _, pending := c.batches.LoadAndDelete(id); !pending {
+			continue
+		}
+		if entry, ok := c.inFlight.LoadAndDelete(id); ok {
+			entry.(*inFlightRequest).complete(async.NewResultErr[*Response](e))
+		}
+	}
+	return true
+}
+
+func (c *client) onBatchResponse(resps []Response) {
+	var b *batch
+	received := make(map[string]bool, len(resps))
+
+	for i := range resps {
+		id := canonicalId(c.opts.Codec, resps[i].Id)
+		received[id] = true
+
+		if value, ok := c.batches.Load(id); ok {
+			b = value.(*batch)
+		}
+
+		c.onResponse(&resps[i])
+	}
+
+	if b == nil {
+		return
+	}
+
+	// the server may omit responses within a batch, complete any that are outstanding so the batch
+	// does not wait forever
+	for i, id := range b.ids {
+		if received[id] {
+			continue
+		}
+		missing, err := NewResponseError(ErrMissingResponse)
+		if err != nil {
+			c.logger().Error("failed to create missing response", "error", err, "id", string(b.rawIds[i]))
+			continue
+		}
+		missing.Id = b.rawIds[i]
+		c.onResponse(missing)
+	}
+}
+
+// batchReply collects the replies to a batch of requests received from the peer, which the spec requires to
+// be sent as a single array once the whole batch has been handled. Nothing is sent for a batch made up only
+// of notifications.
+type batchReply struct {
+	client  *client
+	lock    sync.Mutex
+	resps   []*Response
+	pending int
+}
+
+// newBatchReply returns a batchReply which is held open until done is called, allowing the elements of the
+// batch to be handled first.
+func (c *client) newBatchReply() *batchReply {
+	return &batchReply{client: c, pending: 1}
+}
+
+// expect records that a reply will be added for a request which is yet to be handled.
+func (b *batchReply) expect() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.pending++
+}
+
+func (b *batchReply) add(resp *Response) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.resps = append(b.resps, resp)
+}
+
+// done releases an expected reply, or the hold taken by newBatchReply, sending the replies once none remain.
+func (b *batchReply) done() {
+	b.lock.Lock()
+	b.pending--
+	if b.pending > 0 || len(b.resps) == 0 {
+		b.lock.Unlock()
+		return
+	}
+	resps := b.resps
+	b.lock.Unlock()
+
+	c := b.client
+	bytes, err := c.opts.Codec.Marshal(resps)
+	if err != nil {
+		c.logger().Error("failed to marshal batch response", "error", err)
+		return
+	}
+
+	if err := c.write(nil, nil, bytes); err != nil {
+		c.logger().Warn("failed to send batch response", "error", err)
+	}
+}
@@ -0,0 +1,120 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_SendBatch(t *testing.T) {
+	srv := newWsServer(false)
+	defer srv.close()
+
+	dialer := jsonrpc.WebSocketDialer{Url: srv.url("/ws")}
+	client := jsonrpc.NewClient(dialer)
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	var reqs []jsonrpc.Request
+	var expected []jsonrpc.Response
+	for i := 0; i < 10; i++ {
+		reqs = append(reqs, *newRequest("eth_getBlockByNumber", []any{i, false}, jsonrpc.RequestNumericId(i)))
+		expected = append(expected, *newResponse(i, jsonrpc.ResponseNumericId(i)))
+	}
+
+	// reply in reverse order to ensure responses are correlated by id
+	var reply []jsonrpc.Response
+	for i := len(expected) - 1; i >= 0; i-- {
+		reply = append(reply, expected[i])
+	}
+
+	replyBytes, err := json.Marshal(reply)
+	assert.Nil(t, err)
+	srv.testMessages <- testMessage{msgType: websocket.TextMessage, data: replyBytes}
+
+	resps, err := client.SendBatch(context.Background(), reqs)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, resps)
+}
+
+func TestClient_SendBatchMissingResponses(t *testing.T) {
+	srv := newWsServer(false)
+	defer srv.close()
+
+	dialer := jsonrpc.WebSocketDialer{Url: srv.url("/ws")}
+	client := jsonrpc.NewClient(dialer)
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	reqs := []jsonrpc.Request{
+		*newRequest("ping", nil, jsonrpc.RequestNumericId(1)),
+		*newRequest("ping", nil, jsonrpc.RequestNumericId(2)),
+		*newRequest("ping", nil, jsonrpc.RequestNumericId(3)),
+	}
+
+	// omit the response for the second request
+	reply := []jsonrpc.Response{
+		*newResponse("pong", jsonrpc.ResponseNumericId(1)),
+		*newResponse("pong", jsonrpc.ResponseNumericId(3)),
+	}
+
+	replyBytes, err := json.Marshal(reply)
+	assert.Nil(t, err)
+	srv.testMessages <- testMessage{msgType: websocket.TextMessage, data: replyBytes}
+
+	resps, err := client.SendBatch(context.Background(), reqs)
+	assert.Nil(t, err)
+	assert.Len(t, resps, 3)
+
+	assert.Equal(t, reply[0], resps[0])
+	assert.Equal(t, json.RawMessage("2"), resps[1].Id)
+	assert.Equal(t, jsonrpc.ErrMissingResponse, *resps[1].Error)
+	assert.Equal(t, reply[1], resps[2])
+
+	var result string
+	err = resps[1].UnmarshalResult(&result)
+	assert.Equal(t, jsonrpc.ErrMissingResponse, *err.(*jsonrpc.Error))
+}
+
+func TestClient_SendBatchEmpty(t *testing.T) {
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{})
+	_, err := client.SendBatch(context.Background(), nil)
+	assert.Equal(t, jsonrpc.ErrEmptyBatch, err)
+}
+
+func TestClient_SendBatchRejected(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+		// the batch is rejected as a whole with a single error object
+		_ = c.WriteMessage(websocket.TextMessage,
+			[]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid request"}}`))
+		_, _, _ = c.ReadMessage()
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	future := client.SendBatchAsync([]jsonrpc.Request{
+		*newRequest("ping", nil, jsonrpc.RequestNumericId(1)),
+		*newRequest("ping", nil, jsonrpc.RequestNumericId(2)),
+	})
+
+	select {
+	case result := <-future.Get():
+		_, err := result.Unwrap()
+		assert.Equal(t, jsonrpc.ErrInvalidRequest, err)
+	case <-time.After(time.Second):
+		t.Fatal("batch was not failed")
+	}
+}
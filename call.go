@@ -0,0 +1,39 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Call sends a request for method with params and unmarshals the result into R. If the response contains
+// an error it is returned as an *Error.
+func Call[P, R any](ctx context.Context, client Client, method string, params P) (R, error) {
+	var result R
+
+	codec := codecOf(client)
+
+	req, err := NewRequest(method, params, RequestCodec(codec))
+	if err != nil {
+		return result, err
+	}
+
+	// the result is decoded as it is read, a null result leaves the zero value
+	var resp Response
+	err = client.SendDecode(ctx, *req, &resp, func(data json.RawMessage) error {
+		return codec.Unmarshal(data, &result)
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if resp.Error != nil {
+		return result, resp.Error
+	}
+
+	return result, nil
+}
+
+// Notify sends a notification for method with params, returning once it has been written.
+func Notify[P any](ctx context.Context, client Client, method string, params P) error {
+	return client.Notify(ctx, method, params)
+}
@@ -0,0 +1,112 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newServerClient serves srv over a websocket endpoint and returns a client connected to it.
+func newServerClient(t *testing.T, srv *jsonrpc.Server, options ...jsonrpc.ClientOption) jsonrpc.Client {
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = srv.Serve(r.Context(), jsonrpc.NewWebSocketConnection(c))
+	}))
+	t.Cleanup(httpSrv.Close)
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(httpSrv)}, options...)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestCall(t *testing.T) {
+	client := newServerClient(t, newTestServer())
+
+	result, err := jsonrpc.Call[[]string, []string](context.Background(), client, "echo", []string{"hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hello"}, result)
+}
+
+func TestCall_Error(t *testing.T) {
+	client := newServerClient(t, newTestServer())
+
+	_, err := jsonrpc.Call[map[string]string, []string](context.Background(), client, "echo", map[string]string{})
+
+	var rpcErr *jsonrpc.Error
+	assert.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, jsonrpc.ErrInvalidParams, *rpcErr)
+}
+
+func TestCall_MethodNotFound(t *testing.T) {
+	client := newServerClient(t, newTestServer())
+
+	// the error message contains "method", which must not cause the response to be mistaken for a request
+	_, err := jsonrpc.Call[[]string, []string](context.Background(), client, "unknown", nil)
+
+	var rpcErr *jsonrpc.Error
+	assert.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, jsonrpc.ErrMethodNotFound, *rpcErr)
+}
+
+func TestNotify(t *testing.T) {
+	notifications := make(chan jsonrpc.Request, 1)
+
+	srv := jsonrpc.NewServer()
+	srv.Register("log", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		notifications <- req
+		return nil, nil
+	})
+
+	client := newServerClient(t, srv)
+
+	err := jsonrpc.Notify(context.Background(), client, "log", map[string]string{"level": "info"})
+	assert.Nil(t, err)
+
+	req := <-notifications
+	assert.Nil(t, req.Id)
+	assert.Equal(t, "log", req.Method)
+	assert.JSONEq(t, `{"level":"info"}`, string(req.Params))
+}
+
+func TestCall_Middleware(t *testing.T) {
+	client := newServerClient(t, newTestServer())
+
+	// a caching middleware must see the result of a typed call
+	var cached *jsonrpc.Response
+	client.Use(func(next jsonrpc.Invoker) jsonrpc.Invoker {
+		return func(ctx context.Context, req jsonrpc.Request) (*jsonrpc.Response, error) {
+			if cached != nil {
+				resp := *cached
+				resp.Id = req.Id
+				return &resp, nil
+			}
+			resp, err := next(ctx, req)
+			if err == nil {
+				cached = resp
+			}
+			return resp, err
+		}
+	})
+
+	result, err := jsonrpc.Call[[]string, []string](context.Background(), client, "echo", []string{"hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hello"}, result)
+	assert.JSONEq(t, `["hello"]`, string(cached.Result))
+
+	result, err = jsonrpc.Call[[]string, []string](context.Background(), client, "echo", []string{"ignored"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hello"}, result)
+}
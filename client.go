@@ -1,28 +1,31 @@
 package jsonrpc
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/41north/async.go"
 	"github.com/juju/errors"
 	gonanoid "github.com/matoous/go-nanoid"
-	log "github.com/sirupsen/logrus"
 )
 
 var (
-	idGen = func() string { return gonanoid.MustID(20) }
-
-	ErrClosed = errors.ConstError("connection has been closed")
+	ErrClosed      = errors.ConstError("connection has been closed")
+	ErrMaxInFlight = errors.ConstError("maximum number of in-flight requests reached")
 )
 
 type (
 	ResponseFuture = async.Future[async.Result[*Response]]
 	RequestHandler = func(req Request)
 	CloseHandler   = func(err error)
+
+	// ResultDecoder decodes the result of a response in place, from the buffer the message was read into.
+	// The result is only valid until the decoder returns.
+	ResultDecoder = func(result json.RawMessage) error
 )
 
 type Client interface {
@@ -32,109 +35,690 @@ type Client interface {
 	SendContext(ctx context.Context, req Request, resp *Response) error
 	SendAsync(req Request) ResponseFuture
 
+	// SendDecode sends a request and passes the result to decode on the read loop, saving it from being
+	// copied into resp. The message is still read in full and scanned before decode is called, so this saves
+	// an allocation of the size of the result rather than any parsing. When middleware has been added the
+	// result is retained, so that the middleware sees it, and is decoded once the response has been returned.
+	SendDecode(ctx context.Context, req Request, resp *Response, decode ResultDecoder) error
+
+	SendBatch(ctx context.Context, reqs []Request) ([]Response, error)
+	SendBatchAsync(reqs []Request) BatchFuture
+
+	Subscribe(ctx context.Context, method string, params any) (Subscription, error)
+
+	// Notify sends a request without an id, returning once it has been written. No response is expected.
+	Notify(ctx context.Context, method string, params any) error
+
+	// Use adds middleware through which every request sent with SendContext, SendAsync or Notify passes.
+	// Batches bypass the middleware.
+	Use(middleware ...Middleware)
+
+	// UseHandler adds middleware through which every request delivered to the RequestHandler passes.
+	UseHandler(middleware ...HandlerMiddleware)
+
 	SetCloseHandler(handler CloseHandler)
 	SetRequestHandler(handler RequestHandler)
 
+	// SetReplyHandler sets a handler for incoming requests whose result is sent back to the peer as a
+	// response, taking precedence over the RequestHandler. Notifications are handled but not replied to.
+	SetReplyHandler(handler Handler)
+
+	// Stats reports the current load on the client.
+	Stats() ClientStats
+
+	// Close closes the client immediately, failing any in-flight requests with ErrClosed.
 	Close() error
+
+	// Shutdown closes the client gracefully. New requests are refused with ErrClosed whilst in-flight
+	// requests are given until ctx is done to complete, after which the connection is closed and Shutdown
+	// waits for the read loop to exit. If ctx is done first, its error is returned.
+	Shutdown(ctx context.Context) error
+}
+
+type ClientStats struct {
+	// InFlight is the number of requests awaiting a response.
+	InFlight int
+	// WriteQueueDepth is the number of messages waiting to be written, for connections which queue writes.
+	WriteQueueDepth int
+	// Dispatcher reports the handling of incoming requests.
+	Dispatcher DispatcherStats
+}
+
+// ClientReconnectPolicy enables automatic reconnection when the connection is lost.
+func ClientReconnectPolicy(policy ReconnectPolicy) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.ReconnectPolicy = &policy
+	}
+}
+
+func ClientDisconnectHandler(handler DisconnectHandler) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.DisconnectHandler = handler
+	}
+}
+
+func ClientReconnectHandler(handler ReconnectHandler) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.ReconnectHandler = handler
+	}
+}
+
+// ClientSubscriptionBuffer sets how many notifications are buffered per subscription before it is ended
+// with ErrSubscriptionOverflow.
+func ClientSubscriptionBuffer(size int) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.SubscriptionBuffer = size
+	}
+}
+
+// ClientRequestTimeout sets a default timeout for every request, after which the request fails with
+// context.DeadlineExceeded.
+func ClientRequestTimeout(timeout time.Duration) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.RequestTimeout = timeout
+	}
+}
+
+// ClientCancelNotification sets the method of a notification, such as `$/cancelRequest`, which is sent
+// with the id of any request that is cancelled or times out before a response is received.
+func ClientCancelNotification(method string) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.CancelNotification = method
+	}
+}
+
+func ClientLogger(logger Logger) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.Logger = logger
+	}
+}
+
+// ClientIdGenerator sets the generator used to assign string ids to requests which do not already have one.
+func ClientIdGenerator(gen IdGenerator) ClientOption {
+	return ClientIdStrategy(StringIds(gen))
+}
+
+// ClientIdStrategy sets the strategy used to assign ids to requests which do not already have one.
+func ClientIdStrategy(strategy IdStrategy) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.IdStrategy = strategy
+	}
+}
+
+// ClientHandlerConcurrency sets how many incoming requests may be handled concurrently. With a
+// concurrency of one, the default, requests are handled in order on the read loop. It is shorthand for a
+// PoolDispatcher with no queue, and is ignored if a Dispatcher has been set.
+func ClientHandlerConcurrency(concurrency int) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.HandlerConcurrency = concurrency
+	}
+}
+
+// ClientDispatcher sets how incoming requests are handed to the request handler. The dispatcher belongs to
+// the caller and is not closed along with the client, allowing it to be shared between clients.
+func ClientDispatcher(dispatcher Dispatcher) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.Dispatcher = dispatcher
+	}
+}
+
+// ClientMaxInFlight limits the number of requests awaiting a response, further requests fail with
+// ErrMaxInFlight. Zero means no limit.
+func ClientMaxInFlight(max int) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.MaxInFlight = max
+	}
+}
+
+// ClientCloseConnection determines whether Close also closes the underlying Connection, which it does by
+// default.
+func ClientCloseConnection(closeConnection bool) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.CloseConnection = closeConnection
+	}
+}
+
+type ClientOption = func(opts *ClientOptions)
+
+type ClientOptions struct {
+	ReconnectPolicy    *ReconnectPolicy
+	DisconnectHandler  DisconnectHandler
+	ReconnectHandler   ReconnectHandler
+	SubscriptionBuffer int
+	RequestTimeout     time.Duration
+	CancelNotification string
+	Logger             Logger
+	IdStrategy         IdStrategy
+	HandlerConcurrency int
+	Dispatcher         Dispatcher
+	MaxInFlight        int
+	CloseConnection    bool
+	Codec              Codec
+
+	InvalidMessageHandler InvalidMessageHandler
+}
+
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		SubscriptionBuffer: 128,
+		Logger:             defaultLogger(),
+		IdStrategy:         NanoIds(20),
+		HandlerConcurrency: 1,
+		Codec:              JSONCodec(),
+		CloseConnection:    true,
+	}
+}
+
+// inFlightRequest is a request which is awaiting a response. The marshalled request is retained so that it
+// can be replayed after a reconnect.
+type inFlightRequest struct {
+	future    ResponseFuture
+	data      []byte
+	timer     *time.Timer
+	release   func()
+	decode    ResultDecoder
+	decodeErr error
+	// replayed is the generation of the latest restore to replay the request
+	replayed atomic.Uint64
+}
+
+// replaying claims the request for replay by the restore of the given generation, returning false if it has
+// already been replayed by that or a later restore.
+func (r *inFlightRequest) replaying(generation uint64) bool {
+	for {
+		replayed := r.replayed.Load()
+		if replayed >= generation {
+			return false
+		}
+		if r.replayed.CompareAndSwap(replayed, generation) {
+			return true
+		}
+	}
+}
+
+func (r *inFlightRequest) complete(result async.Result[*Response]) {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	if r.release != nil {
+		r.release()
+	}
+	r.future.Set(result)
+}
+
+// acquireInFlight reserves count in flight slots, returning a release func for each.
+func (c *client) acquireInFlight(count int) ([]func(), error) {
+	releases := make([]func(), count)
+	if c.inFlightSlots == nil {
+		return releases, nil
+	}
+
+	for i := range releases {
+		select {
+		case c.inFlightSlots <- struct{}{}:
+			var once sync.Once
+			releases[i] = func() { once.Do(func() { <-c.inFlightSlots }) }
+		default:
+			// release what has been acquired so far
+			for _, release := range releases[:i] {
+				release()
+			}
+			return nil, ErrMaxInFlight
+		}
+	}
+
+	return releases, nil
 }
 
 type client struct {
-	dialer       Dialer
-	conn         Connection
-	inFlight     sync.Map
-	log          *log.Entry
-	closed       atomic.Bool
-	reqHandler   RequestHandler
-	closeError   error
-	closeHandler CloseHandler
+	dialer               Dialer
+	opts                 ClientOptions
+	conn                 Connection
+	connLock             sync.RWMutex
+	reconnecting         atomic.Bool
+	replays              atomic.Uint64
+	inFlight             sync.Map
+	batches              sync.Map
+	batchSeq             atomic.Uint64
+	subscriptions        sync.Map
+	pendingSubscriptions sync.Map
+	log                  atomic.Value
+	closed               atomic.Bool
+	shuttingDown         atomic.Bool
+	closing              chan struct{}
+	readers              sync.WaitGroup
+	reqHandler           RequestHandler
+	replyHandler         Handler
+	handlerCtx           context.Context
+	cancelHandlers       context.CancelFunc
+	middleware           []Middleware
+	handlerMiddleware    []HandlerMiddleware
+	middlewareLock       sync.RWMutex
+	dispatcher           Dispatcher
+	ownsDispatcher       bool
+	inFlightSlots        chan struct{}
+	closeHandler         CloseHandler
 }
 
-func NewClient(dialer Dialer) Client {
-	return &client{
-		dialer: dialer,
+func NewClient(dialer Dialer, options ...ClientOption) Client {
+	opts := DefaultClientOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if opts.Logger == nil {
+		opts.Logger = NopLogger()
+	}
+	if opts.IdStrategy == nil {
+		opts.IdStrategy = NanoIds(20)
+	}
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec()
 	}
+	c := &client{
+		dialer:  dialer,
+		opts:    opts,
+		closing: make(chan struct{}),
+	}
+	c.handlerCtx, c.cancelHandlers = context.WithCancel(context.Background())
+	switch {
+	case opts.Dispatcher != nil:
+		c.dispatcher = opts.Dispatcher
+	case opts.HandlerConcurrency > 1:
+		c.dispatcher = PoolDispatcher(opts.HandlerConcurrency, 0)
+		c.ownsDispatcher = true
+	default:
+		c.dispatcher = InlineDispatcher()
+		c.ownsDispatcher = true
+	}
+	if opts.MaxInFlight > 0 {
+		c.inFlightSlots = make(chan struct{}, opts.MaxInFlight)
+	}
+	c.log.Store(opts.Logger)
+	return c
+}
+
+func (c *client) logger() Logger {
+	return c.log.Load().(Logger)
+}
+
+// onConnected is called whenever a new connection is established, giving it a unique id for logging.
+func (c *client) onConnected() {
+	connectionId := gonanoid.MustID(12)
+	c.log.Store(c.opts.Logger.With("connectionId", connectionId))
+	c.logger().Debug("connected")
 }
 
 func (c *client) Connect() error {
-	conn, err := c.dialer.Dial()
+	conn, err := c.dialer.DialContext(c.dialContext())
 	if err != nil {
 		return err
 	}
 
+	c.watchWrites(conn)
+
+	c.connLock.Lock()
 	c.conn = conn
-	c.inFlight = sync.Map{}
-	c.log = log.WithField("connectionId", "tbd")
+	c.connLock.Unlock()
 
-	go c.readMessages()
+	c.onConnected()
+
+	c.readers.Add(1)
+	go c.readMessages(conn)
 
 	return nil
 }
 
 func (c *client) SetRequestHandler(handler RequestHandler) {
+	c.middlewareLock.Lock()
+	defer c.middlewareLock.Unlock()
 	c.reqHandler = handler
 }
 
+func (c *client) Stats() ClientStats {
+	stats := ClientStats{Dispatcher: c.dispatcher.Stats()}
+
+	c.inFlight.Range(func(key, value any) bool {
+		stats.InFlight++
+		return true
+	})
+
+	c.connLock.RLock()
+	if queued, ok := c.conn.(QueuedConnection); ok {
+		stats.WriteQueueDepth = queued.WriteQueueDepth()
+	}
+	c.connLock.RUnlock()
+
+	return stats
+}
+
+func (c *client) SetReplyHandler(handler Handler) {
+	c.middlewareLock.Lock()
+	defer c.middlewareLock.Unlock()
+	c.replyHandler = handler
+}
+
 func (c *client) SetCloseHandler(handler CloseHandler) {
 	c.closeHandler = handler
 }
 
-func (c *client) readMessages() {
+func (c *client) readMessages(conn Connection) {
+	defer c.readers.Done()
+
 	for !c.closed.Load() {
 		// read the next response
-		bytes, err := c.conn.Read()
+		bytes, err := conn.Read()
 		if err != nil {
-			// set the client has closed and break out of the read loop
-			if err == ErrClosed {
-				c.closeError = err
-				c.Close()
+			// the connection has been lost, break out of the read loop
+			if errors.Is(err, ErrClosed) {
+				c.onDisconnect(conn, err)
 				break
 			}
 
 			// otherwise log the error
-			c.log.WithError(err).Error("read failure")
+			c.logger().Error("read failure", "error", err)
+			continue
 		}
 
-		hasMethod := strings.Contains(string(bytes), "method")
-		if hasMethod {
-			// we assume this is a notification
-			var req Request
-			if err := json.Unmarshal(bytes, &req); err != nil {
-				c.log.WithError(err).Error("unmarshal failure")
-			} else {
-				c.reqHandler(req)
-			}
+		c.onMessage(bytes)
+
+		if pooled, ok := conn.(PooledConnection); ok {
+			pooled.Release(bytes)
+		}
+	}
+}
+
+func (c *client) onMessage(data []byte) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		c.onBatch(data)
+		return
+	}
+
+	if resp := c.onElement(data, nil); resp != nil {
+		if resp.Error != nil && isNull(rawView(resp.Id)) && c.onBatchRejected(*resp.Error) {
+			return
+		}
+		c.onResponse(resp)
+	}
+}
+
+func (c *client) onBatch(data []byte) {
+	var elements []json.RawMessage
+	if err := c.opts.Codec.Unmarshal(data, &elements); err != nil {
+		c.onInvalid(data, nil, ErrParse, nil)
+		return
+	}
+
+	if len(elements) == 0 {
+		c.onInvalid(data, nil, ErrInvalidRequest, nil)
+		return
+	}
+
+	// the replies to a batch of requests are sent together once every element has been handled
+	replies := c.newBatchReply()
+	defer replies.done()
+
+	var resps []Response
+	for _, element := range elements {
+		if resp := c.onElement(element, replies); resp != nil {
+			resps = append(resps, *resp)
+		}
+	}
+
+	if len(resps) > 0 {
+		c.onBatchResponse(resps)
+	}
+}
+
+func (c *client) onRequest(req Request, replies *batchReply) {
+	if c.onNotification(req) {
+		return
+	}
+	handler, replying := c.requestHandler(replies)
+	if handler == nil {
+		c.logger().Warn("request received but no request handler has been set", "method", req.Method)
+		return
+	}
+
+	if replying && replies != nil && len(req.Id) > 0 {
+		replies.expect()
+	}
+
+	c.dispatcher.Dispatch(req, handler)
+}
+
+// reply adapts handler into a RequestHandler which sends the result back to the peer, or adds it to replies
+// when the request is part of a batch. The context passed to handler is cancelled when the client is closed.
+func (c *client) reply(handler Handler, replies *batchReply) RequestHandler {
+	return func(req Request) {
+		result, err := c.invokeReplyHandler(handler, req)
+
+		if len(req.Id) == 0 {
+			// notifications do not receive a response
+			return
+		}
+
+		var resp *Response
+		if err != nil {
+			resp, err = NewResponseError(toError(err), ResponseId(req.Id))
 		} else {
-			// otherwise we assume it is a response
-			var resp Response
-			if err := json.Unmarshal(bytes, &resp); err != nil {
-				c.log.WithError(err).Error("unmarshal failure")
-			} else {
-				c.onResponse(&resp)
-			}
+			resp, err = NewResponse(result, ResponseId(req.Id), ResponseCodec(c.opts.Codec))
+		}
+		if err != nil {
+			c.logger().Error("failed to create response", "error", err, "method", req.Method, "id", string(req.Id))
+			resp, _ = NewResponseError(ErrInternal, ResponseId(req.Id))
+		}
+
+		if replies != nil {
+			replies.add(resp)
+			replies.done()
+			return
+		}
+
+		bytes, err := c.opts.Codec.Marshal(resp)
+		if err != nil {
+			c.logger().Error("failed to marshal response", "error", err, "method", req.Method, "id", string(req.Id))
+			return
+		}
+
+		if err := c.write(nil, nil, bytes); err != nil {
+			c.logger().Warn("failed to send response", "error", err, "method", req.Method, "id", string(req.Id))
 		}
 	}
 }
 
+func (c *client) invokeReplyHandler(handler Handler, req Request) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger().Error("handler panic", "panic", r, "method", req.Method, "id", string(req.Id))
+			result, err = nil, ErrInternal
+		}
+	}()
+	return handler(c.handlerCtx, req)
+}
+
 func (c *client) onResponse(resp *Response) {
-	future, ok := c.inFlight.LoadAndDelete(string(resp.Id))
+	key := canonicalId(c.opts.Codec, resp.Id)
+	c.batches.Delete(key)
+	if sub, ok := c.pendingSubscriptions.LoadAndDelete(key); ok {
+		c.onSubscribed(sub.(*subscription), resp)
+	}
+	value, ok := c.inFlight.LoadAndDelete(key)
 	if !ok {
-		c.log.
-			WithField("id", resp.Id).
-			Warn("response received with unrecognised id")
+		c.logger().Warn("response received with unrecognised id", "id", string(resp.Id))
+		return
+	}
+
+	entry := value.(*inFlightRequest)
+	if entry.decodeErr != nil {
+		entry.complete(async.NewResultErr[*Response](entry.decodeErr))
+		return
+	}
+	entry.complete(async.NewResultValue[*Response](resp))
+}
+
+// watchWrites registers for failures of messages written over conn, when it writes in the background.
+func (c *client) watchWrites(conn Connection) {
+	if asyncConn, ok := conn.(AsyncConnection); ok {
+		asyncConn.SetWriteFailureHandler(c.onWriteFailure)
+	}
+}
+
+// onWriteFailure fails each request within data, which is either a single message or a batch.
+func (c *client) onWriteFailure(data []byte, err error) {
+	type message struct {
+		Id json.RawMessage `json:"id"`
+	}
+
+	var messages []message
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		if unmarshalErr := c.opts.Codec.Unmarshal(data, &messages); unmarshalErr != nil {
+			c.logger().Error("failed to unmarshal failed batch", "error", unmarshalErr)
+			return
+		}
+	} else {
+		var msg message
+		if unmarshalErr := c.opts.Codec.Unmarshal(data, &msg); unmarshalErr != nil {
+			c.logger().Error("failed to unmarshal failed message", "error", unmarshalErr)
+			return
+		}
+		messages = append(messages, msg)
+	}
+
+	for _, msg := range messages {
+		if msg.Id == nil {
+			continue
+		}
+		key := canonicalId(c.opts.Codec, msg.Id)
+		c.batches.Delete(key)
+		c.pendingSubscriptions.Delete(key)
+		if entry, ok := c.inFlight.LoadAndDelete(key); ok {
+			entry.(*inFlightRequest).complete(async.NewResultErr[*Response](err))
+		}
+	}
+}
+
+func (c *client) failInFlight(err error) {
+	c.inFlight.Range(func(key, value any) bool {
+		c.inFlight.Delete(key)
+		c.batches.Delete(key)
+		value.(*inFlightRequest).complete(async.NewResultErr[*Response](err))
+		return true
+	})
+}
+
+// cancel removes the in flight entry for id, failing it with err. If configured, the server is notified
+// that the response is no longer required.
+func (c *client) cancel(id json.RawMessage, err error) {
+	key := canonicalId(c.opts.Codec, id)
+	c.batches.Delete(key)
+
+	entry, ok := c.inFlight.LoadAndDelete(key)
+	if !ok {
+		// the response has already been received
+		return
+	}
+	entry.(*inFlightRequest).complete(async.NewResultErr[*Response](err))
+
+	if method := c.opts.CancelNotification; method != "" {
+		params := map[string]json.RawMessage{"id": id}
+		if err := c.Notify(context.Background(), method, params); err != nil {
+			c.logger().Debug("failed to send cancel notification", "error", err, "id", string(id))
+		}
 	}
-	future.(ResponseFuture).Set(async.NewResultValue[*Response](resp))
 }
 
 func (c *client) Close() error {
-	if c.closed.CompareAndSwap(false, true) {
-		// cancel any in flight requests
+	return c.close(nil)
+}
+
+func (c *client) Shutdown(ctx context.Context) error {
+	if c.closed.Load() || !c.shuttingDown.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+
+	drainErr := c.drain(ctx)
+
+	// anything still in flight is failed with ErrClosed
+	_ = c.close(nil)
+	c.closeConnection()
+
+	readersDone := make(chan struct{})
+	go func() {
+		c.readers.Wait()
+		close(readersDone)
+	}()
+
+	select {
+	case <-readersDone:
+		return drainErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain waits until there are no requests in flight, or ctx is done.
+func (c *client) drain(ctx context.Context) error {
+	for {
+		var pending []*inFlightRequest
 		c.inFlight.Range(func(key, value any) bool {
-			value.(ResponseFuture).Set(async.NewResultErr[*Response](ErrClosed))
+			pending = append(pending, value.(*inFlightRequest))
 			return true
 		})
 
+		if len(pending) == 0 {
+			return nil
+		}
+
+		for _, entry := range pending {
+			select {
+			case <-entry.future.Get():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// stopped reports whether new requests are refused, because the client is either closed or shutting down.
+func (c *client) stopped() bool {
+	return c.closed.Load() || c.shuttingDown.Load()
+}
+
+// close closes the client, with cause being passed to the close handler.
+func (c *client) close(cause error) error {
+	if c.closed.CompareAndSwap(false, true) {
+		close(c.closing)
+
+		// end any subscriptions, informing the server where possible. A peer which has stopped reading
+		// must not prevent the client from closing
+		unsubscribed := make(chan struct{})
+		go func() {
+			defer close(unsubscribed)
+			c.unsubscribeAll()
+		}()
+		select {
+		case <-unsubscribed:
+		case <-time.After(closeTimeout):
+			c.logger().Debug("timed out unsubscribing")
+		}
+		c.endSubscriptions(ErrClosed)
+
+		// cancel any in flight requests
+		c.failInFlight(ErrClosed)
+
+		if c.opts.CloseConnection {
+			c.closeConnection()
+		}
+
+		// handlers are cancelled first, as a full dispatcher cannot close until one of them returns
+		c.cancelHandlers()
+		if c.ownsDispatcher {
+			c.dispatcher.Close()
+		}
+
 		if c.closeHandler != nil {
-			c.closeHandler(c.closeError)
+			c.closeHandler(cause)
 		}
 
 		return nil
@@ -143,59 +727,221 @@ func (c *client) Close() error {
 	}
 }
 
+func (c *client) closeConnection() {
+	c.connLock.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.connLock.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	if err := conn.Close(); err != nil {
+		c.logger().Debug("failed to close connection", "error", err)
+	}
+}
+
 func (c *client) Send(req Request, resp *Response) error {
 	return c.SendContext(context.Background(), req, resp)
 }
 
 func (c *client) SendContext(ctx context.Context, req Request, resp *Response) error {
-	future := c.SendAsync(req)
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case result := <-future.Get():
-		r, err := result.Unwrap()
-		if err != nil {
+	// ensure a request id before the request passes through any middleware
+	if err := req.EnsureIdWith(c.opts.IdStrategy); err != nil {
+		return err
+	}
+
+	if timeout := c.opts.RequestTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	invoker := c.invoker()
+	if invoker == nil {
+		invoker = c.invoke
+	}
+
+	r, err := invoker(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// TODO can this copy be removed?
+	resp.Id = r.Id
+	resp.Result = r.Result
+	resp.Error = r.Error
+	resp.Version = r.Version
+	return nil
+}
+
+func (c *client) SendAsync(req Request) ResponseFuture {
+	// ensure a request id before the request passes through any middleware
+	if err := req.EnsureIdWith(c.opts.IdStrategy); err != nil {
+		return async.NewFutureImmediate[async.Result[*Response]](async.NewResultErr[*Response](err))
+	}
+
+	if invoker := c.invoker(); invoker != nil {
+		return c.invokeAsync(invoker, req)
+	}
+
+	return c.sendAsync(req, c.opts.RequestTimeout, nil)
+}
+
+// resultDecoderKey is the context key with which SendDecode passes its decoder to invoke.
+type resultDecoderKey struct{}
+
+func (c *client) SendDecode(ctx context.Context, req Request, resp *Response, decode ResultDecoder) error {
+	if c.invoker() != nil {
+		// middleware such as caching or logging needs the result
+		if err := c.SendContext(ctx, req, resp); err != nil {
 			return err
 		}
-		// TODO can this copy be removed?
-		resp.Id = r.Id
-		resp.Result = r.Result
-		resp.Error = r.Error
-		resp.Version = r.Version
+		if resp.Error == nil && resp.Result != nil {
+			return decode(resp.Result)
+		}
 		return nil
 	}
+
+	// the request may be cancelled whilst the result is being decoded on the read loop, in which case the
+	// decoder must finish before returning so that the caller does not race with it
+	var started atomic.Bool
+	done := make(chan struct{})
+	guarded := func(result json.RawMessage) error {
+		if !started.CompareAndSwap(false, true) {
+			return context.Canceled
+		}
+		defer close(done)
+		return decode(result)
+	}
+
+	ctx = context.WithValue(ctx, resultDecoderKey{}, guarded)
+	if err := c.SendContext(ctx, req, resp); err != nil {
+		if !started.CompareAndSwap(false, true) {
+			<-done
+		}
+		return err
+	}
+
+	return nil
 }
 
-func (c *client) SendAsync(req Request) ResponseFuture {
+// sendAsync sends the request, failing it with context.DeadlineExceeded if no response has been received
+// within the timeout. A timeout of zero means no timeout. When decode is set the result is decoded on the
+// read loop rather than being retained in the response.
+func (c *client) sendAsync(req Request, timeout time.Duration, decode ResultDecoder) ResponseFuture {
 	// create a future for returning the result
 	future := async.NewFuture[async.Result[*Response]]()
 
 	// ensure a request id
-	if err := req.EnsureId(idGen); err != nil {
+	if err := req.EnsureIdWith(c.opts.IdStrategy); err != nil {
 		future.Set(async.NewResultErr[*Response](err))
 		return future
 	}
 
-	if c.closed.Load() {
+	if c.stopped() {
 		// short circuit
 		future.Set(async.NewResultErr[*Response](ErrClosed))
 		return future
 	}
 
 	// marshal to json
-	bytes, err := json.Marshal(req)
+	bytes, err := c.opts.Codec.Marshal(req)
 	if err != nil {
 		future.Set(async.NewResultErr[*Response](errors.Annotate(err, "failed to marshal request to json")))
 		return future
 	}
 
-	// create an in flight entry
-	c.inFlight.Store(string(req.Id), future)
-
-	// send the request
-	if err := c.conn.Write(bytes); err != nil {
+	// create an in flight entry and send the request
+	releases, err := c.acquireInFlight(1)
+	if err != nil {
 		future.Set(async.NewResultErr[*Response](err))
+		return future
+	}
+
+	entry := &inFlightRequest{future: future, data: bytes, release: releases[0], decode: decode}
+	if timeout > 0 {
+		id := req.Id
+		entry.timer = time.AfterFunc(timeout, func() {
+			c.cancel(id, context.DeadlineExceeded)
+		})
+	}
+
+	if err := c.write([]string{canonicalId(c.opts.Codec, req.Id)}, []*inFlightRequest{entry}, bytes); err != nil {
+		entry.complete(async.NewResultErr[*Response](err))
 	}
 
 	return future
 }
+
+func (c *client) Notify(ctx context.Context, method string, params any) error {
+	// a notification is a request without an id
+	req, err := NewRequest(method, params, RequestCodec(c.opts.Codec))
+	if err != nil {
+		return err
+	}
+
+	if invoker := c.invoker(); invoker != nil {
+		_, err := invoker(ctx, *req)
+		return err
+	}
+
+	return c.notify(ctx, *req)
+}
+
+func (c *client) notify(ctx context.Context, req Request) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if c.stopped() {
+		return ErrClosed
+	}
+
+	bytes, err := c.opts.Codec.Marshal(req)
+	if err != nil {
+		return errors.Annotate(err, "failed to marshal request to json")
+	}
+
+	return c.write(nil, nil, bytes)
+}
+
+// write registers the in flight entries, keyed by canonical id, and sends data over the current connection.
+// Whilst reconnecting the entries are only registered if they will be replayed, otherwise ErrDisconnected
+// is returned.
+func (c *client) write(ids []string, entries []*inFlightRequest, data []byte) error {
+	c.connLock.RLock()
+
+	conn := c.conn
+	if conn == nil {
+		// only requests can be queued for replay, there is nothing to replay for a notification
+		policy := c.opts.ReconnectPolicy
+		if len(ids) == 0 || !(c.reconnecting.Load() && policy != nil && policy.InFlight == ReplayInFlight) {
+			c.connLock.RUnlock()
+			return ErrDisconnected
+		}
+	}
+
+	for i, id := range ids {
+		c.inFlight.Store(id, entries[i])
+	}
+
+	// the lock is not held whilst writing, which may block until the connection is closed if the peer has
+	// stopped reading
+	c.connLock.RUnlock()
+
+	if conn == nil {
+		// queued for replay
+		return nil
+	}
+
+	if err := conn.Write(data); err != nil {
+		for _, id := range ids {
+			c.inFlight.Delete(id)
+		}
+		return err
+	}
+
+	return nil
+}
@@ -1,9 +1,14 @@
 package jsonrpc_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"io"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/41north/jsonrpc.go"
 
@@ -108,6 +113,523 @@ func TestClient_RequestHandling(t *testing.T) {
 	assert.Equal(t, expected, received)
 }
 
+func TestClient_Notify(t *testing.T) {
+	received := make(chan []byte, 1)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		received <- msg
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	err = client.Notify(context.Background(), "telemetry", []int{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"method":"telemetry","params":[1,2,3],"jsonrpc":"2.0"}`, string(<-received))
+
+	err = client.Close()
+	assert.Nil(t, err)
+
+	err = client.Notify(context.Background(), "telemetry", nil)
+	assert.Equal(t, jsonrpc.ErrClosed, err)
+}
+
+func TestClient_SendContextCancel(t *testing.T) {
+	cancelled := make(chan jsonrpc.Request, 1)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+
+		// wait for the cancellation before sending a late response
+		var cancel jsonrpc.Request
+		if err := c.ReadJSON(&cancel); err != nil {
+			return
+		}
+		cancelled <- cancel
+		_ = c.WriteJSON(newResponse("late", jsonrpc.ResponseId(req.Id)))
+
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(newResponse("pong", jsonrpc.ResponseId(req.Id)))
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(
+		jsonrpc.WebSocketDialer{Url: wsUrl(srv)},
+		jsonrpc.ClientCancelNotification("$/cancelRequest"),
+	)
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var resp jsonrpc.Response
+	err = client.SendContext(ctx, *newRequest("ping", nil, jsonrpc.RequestNumericId(1)), &resp)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	req := <-cancelled
+	assert.Equal(t, "$/cancelRequest", req.Method)
+	assert.Nil(t, req.Id)
+	assert.Equal(t, `{"id":1}`, string(req.Params))
+
+	// the late response is discarded and does not affect subsequent requests
+	err = client.Send(*newRequest("ping", nil, jsonrpc.RequestNumericId(2)), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, *newResponse("pong", jsonrpc.ResponseNumericId(2)), resp)
+}
+
+func TestClient_RequestTimeout(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		// never respond
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(
+		jsonrpc.WebSocketDialer{Url: wsUrl(srv)},
+		jsonrpc.ClientRequestTimeout(50*time.Millisecond),
+	)
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	_, err = (<-client.SendAsync(*newRequest("ping", nil)).Get()).Unwrap()
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("ping", nil), &resp)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	_, err = client.SendBatch(context.Background(), []jsonrpc.Request{*newRequest("ping", nil)})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestClient_IdGenerator(t *testing.T) {
+	client := newServerClient(t, newTestServer(), jsonrpc.ClientIdGenerator(func() string { return "fixed" }))
+
+	var resp jsonrpc.Response
+	err := client.Send(*newRequest("echo", []string{}), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`"fixed"`), resp.Id)
+}
+
+func TestClient_MaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		for {
+			var req jsonrpc.Request
+			if err := c.ReadJSON(&req); err != nil {
+				return
+			}
+			<-release
+			_ = c.WriteJSON(newResponse("pong", jsonrpc.ResponseId(req.Id)))
+		}
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientMaxInFlight(1))
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	first := client.SendAsync(*newRequest("ping", nil))
+
+	_, err = (<-client.SendAsync(*newRequest("ping", nil)).Get()).Unwrap()
+	assert.Equal(t, jsonrpc.ErrMaxInFlight, err)
+
+	_, err = client.SendBatch(context.Background(), []jsonrpc.Request{*newRequest("ping", nil)})
+	assert.Equal(t, jsonrpc.ErrMaxInFlight, err)
+
+	// the slot is released once the response has been received
+	release <- struct{}{}
+	_, err = (<-first.Get()).Unwrap()
+	assert.Nil(t, err)
+
+	second := client.SendAsync(*newRequest("ping", nil))
+	release <- struct{}{}
+	_, err = (<-second.Get()).Unwrap()
+	assert.Nil(t, err)
+}
+
+func TestClient_HandlerConcurrency(t *testing.T) {
+	// a handler which sends a request of its own would deadlock the read loop if handled inline
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		_ = c.WriteJSON(newRequest("notify", nil))
+
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(newResponse("pong", jsonrpc.ResponseId(req.Id)))
+
+		// wait for the client to go away
+		_, _, _ = c.ReadMessage()
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientHandlerConcurrency(4))
+
+	results := make(chan error, 1)
+	client.SetRequestHandler(func(req jsonrpc.Request) {
+		var resp jsonrpc.Response
+		results <- client.Send(*newRequest("ping", nil), &resp)
+	})
+
+	err := client.Connect()
+	assert.Nil(t, err)
+	assert.Nil(t, <-results)
+}
+
+func TestClient_CloseConnection(t *testing.T) {
+	closed := make(chan error, 1)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		_, _, err := c.ReadMessage()
+		closed <- err
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientCloseConnection(true))
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	err = client.Close()
+	assert.Nil(t, err)
+	assert.Error(t, <-closed)
+}
+
+func TestClient_ReplyHandler(t *testing.T) {
+	replies := make(chan jsonrpc.Response, 3)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		_ = c.WriteJSON(newRequest("add", []int{1, 2}, jsonrpc.RequestNumericId(1)))
+		_ = c.WriteJSON(newRequest("unknown", nil, jsonrpc.RequestNumericId(2)))
+		_ = c.WriteJSON(newRequest("add", []int{3, 4}))
+		_ = c.WriteJSON(newRequest("panic", nil, jsonrpc.RequestNumericId(3)))
+
+		for i := 0; i < 3; i++ {
+			var resp jsonrpc.Response
+			if err := c.ReadJSON(&resp); err != nil {
+				return
+			}
+			replies <- resp
+		}
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	client.SetReplyHandler(func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		switch req.Method {
+		case "add":
+			var params []int
+			if err := req.UnmarshalParams(&params); err != nil {
+				return nil, err
+			}
+			return params[0] + params[1], nil
+		case "panic":
+			panic("boom")
+		default:
+			return nil, jsonrpc.ErrMethodNotFound
+		}
+	})
+
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	resp := <-replies
+	assert.Equal(t, json.RawMessage("1"), resp.Id)
+	assert.Equal(t, json.RawMessage("3"), resp.Result)
+
+	resp = <-replies
+	assert.Equal(t, json.RawMessage("2"), resp.Id)
+	assert.Equal(t, jsonrpc.ErrMethodNotFound.Code, resp.Error.Code)
+
+	// the notification is not replied to
+	resp = <-replies
+	assert.Equal(t, json.RawMessage("3"), resp.Id)
+	assert.Equal(t, jsonrpc.ErrInternal.Code, resp.Error.Code)
+}
+
+func TestClient_ReplyHandlerBatch(t *testing.T) {
+	replies := make(chan [][]byte, 1)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		batch, _ := json.Marshal([]any{
+			newRequest("add", []int{1, 2}, jsonrpc.RequestNumericId(1)),
+			newRequest("add", []int{3, 4}),
+			1,
+			newRequest("unknown", nil, jsonrpc.RequestNumericId(2)),
+		})
+		_ = c.WriteMessage(websocket.TextMessage, batch)
+
+		// nothing is sent for a batch of notifications
+		notifications, _ := json.Marshal([]any{newRequest("add", []int{1, 2}), newRequest("add", []int{3, 4})})
+		_ = c.WriteMessage(websocket.TextMessage, notifications)
+		_ = c.WriteJSON(newRequest("add", []int{5, 6}, jsonrpc.RequestNumericId(3)))
+
+		// collect everything sent until the client falls quiet
+		var received [][]byte
+		for {
+			_ = c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				break
+			}
+			received = append(received, data)
+		}
+		replies <- received
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientHandlerConcurrency(4))
+	client.SetReplyHandler(func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		if req.Method != "add" {
+			return nil, jsonrpc.ErrMethodNotFound
+		}
+		var params []int
+		if err := req.UnmarshalParams(&params); err != nil {
+			return nil, err
+		}
+		return params[0] + params[1], nil
+	})
+
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	// the replies to the batch are sent together as an array, which may follow the reply to the later request
+	received := <-replies
+	if !assert.Len(t, received, 2) {
+		return
+	}
+	if received[0][0] != '[' {
+		received[0], received[1] = received[1], received[0]
+	}
+
+	var resps []jsonrpc.Response
+	assert.Nil(t, json.Unmarshal(received[0], &resps))
+
+	byId := map[string]jsonrpc.Response{}
+	for _, resp := range resps {
+		byId[string(resp.Id)] = resp
+	}
+	assert.Len(t, byId, 3)
+	assert.Equal(t, json.RawMessage("3"), byId["1"].Result)
+	assert.Equal(t, jsonrpc.ErrMethodNotFound.Code, byId["2"].Error.Code)
+	assert.Equal(t, jsonrpc.ErrInvalidRequest.Code, byId["null"].Error.Code)
+
+	var resp jsonrpc.Response
+	assert.Nil(t, json.Unmarshal(received[1], &resp))
+	assert.Equal(t, json.RawMessage("3"), resp.Id)
+	assert.Equal(t, json.RawMessage("11"), resp.Result)
+}
+
+func TestClient_SendDecode(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		for {
+			var req jsonrpc.Request
+			if err := c.ReadJSON(&req); err != nil {
+				return
+			}
+			if req.Method == "fail" {
+				_ = c.WriteJSON(newResponseError(jsonrpc.ErrInternal, jsonrpc.ResponseId(req.Id)))
+				continue
+			}
+			_ = c.WriteJSON(newResponse([]string{"a", "b", "c"}, jsonrpc.ResponseId(req.Id)))
+		}
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	// the result is decoded in place rather than retained in the response
+	var tokens []json.Token
+	var resp jsonrpc.Response
+	err = client.SendDecode(context.Background(), *newRequest("list", nil), &resp, func(result json.RawMessage) error {
+		dec := json.NewDecoder(bytes.NewReader(result))
+		for {
+			token, err := dec.Token()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			tokens = append(tokens, token)
+		}
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Result)
+	assert.NotNil(t, resp.Id)
+	assert.Equal(t, []json.Token{json.Delim('['), "a", "b", "c", json.Delim(']')}, tokens)
+
+	// decoding failures are returned
+	err = client.SendDecode(context.Background(), *newRequest("list", nil), &resp, func(result json.RawMessage) error {
+		var n int
+		return json.Unmarshal(result, &n)
+	})
+	assert.Error(t, err)
+
+	// error responses are not decoded
+	decoded := false
+	err = client.SendDecode(context.Background(), *newRequest("fail", nil), &resp, func(result json.RawMessage) error {
+		decoded = true
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.False(t, decoded)
+	assert.Equal(t, jsonrpc.ErrInternal, *resp.Error)
+}
+
+func TestClient_SendDecodeMiddleware(t *testing.T) {
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: "ws://localhost:0"})
+
+	// a response returned by middleware is decoded from its result
+	client.Use(func(next jsonrpc.Invoker) jsonrpc.Invoker {
+		return func(ctx context.Context, req jsonrpc.Request) (*jsonrpc.Response, error) {
+			return newResponse("cached", jsonrpc.ResponseId(req.Id)), nil
+		}
+	})
+
+	var result string
+	var resp jsonrpc.Response
+	err := client.SendDecode(context.Background(), *newRequest("get", nil), &resp, func(data json.RawMessage) error {
+		return json.Unmarshal(data, &result)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", result)
+}
+
+func TestClient_Shutdown(t *testing.T) {
+	closeCodes := make(chan int, 1)
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+
+		// reply after the shutdown has begun
+		time.Sleep(100 * time.Millisecond)
+		_ = c.WriteJSON(newResponse("pong", jsonrpc.ResponseId(req.Id)))
+
+		_, _, err := c.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			closeCodes <- closeErr.Code
+		}
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	future := client.SendAsync(*newRequest("ping", nil))
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		done <- client.Shutdown(ctx)
+	}()
+
+	// new requests are refused whilst draining
+	assert.Eventually(t, func() bool {
+		_, err := (<-client.SendAsync(*newRequest("ping", nil)).Get()).Unwrap()
+		return errors.Is(err, jsonrpc.ErrClosed)
+	}, time.Second, time.Millisecond)
+
+	assert.Nil(t, <-done)
+
+	// the in-flight request completed and the peer received a close frame
+	resp, err := (<-future.Get()).Unwrap()
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`"pong"`), resp.Result)
+	assert.Equal(t, websocket.CloseNormalClosure, <-closeCodes)
+
+	assert.ErrorIs(t, client.Shutdown(context.Background()), jsonrpc.ErrClosed)
+}
+
+func TestClient_ShutdownDeadline(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		// never reply
+		_, _, _ = c.ReadMessage()
+		_, _, _ = c.ReadMessage()
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	future := client.SendAsync(*newRequest("ping", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = client.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = (<-future.Get()).Unwrap()
+	assert.ErrorIs(t, err, jsonrpc.ErrClosed)
+}
+
+func TestClient_CloseWhilstPeerNotReading(t *testing.T) {
+	done := make(chan struct{})
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		// never read, so that writes back up
+		<-done
+	})
+	defer srv.Close()
+	defer close(done)
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	assert.Nil(t, client.Connect())
+
+	// writing blocks once the buffers are full, so the requests are sent concurrently
+	req := newRequest("echo", []string{strings.Repeat("a", 1<<20)})
+	futures := make(chan jsonrpc.ResponseFuture, 64)
+	for i := 0; i < cap(futures); i++ {
+		go func() {
+			futures <- client.SendAsync(*req)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("close did not return")
+	}
+
+	for i := 0; i < cap(futures); i++ {
+		// requests sent after closing find no connection
+		_, err := (<-(<-futures).Get()).Unwrap()
+		assert.True(t, errors.Is(err, jsonrpc.ErrClosed) || errors.Is(err, jsonrpc.ErrDisconnected), err)
+	}
+}
+
 // newRequest is an internal test utility for creating request objects without having to handle
 // the possible error, panicking instead.
 func newRequest(method string, params any, options ...jsonrpc.RequestOption) *jsonrpc.Request {
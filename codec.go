@@ -0,0 +1,88 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Codec marshals and unmarshals messages along with their params and results, allowing encoding/json to be
+// replaced with a faster implementation. Only the implementation may be replaced, not the encoding: messages
+// are framed and classified by inspecting their JSON text, for example to tell a batch from a single message
+// or to key ids without decoding them, so a Codec must produce and accept JSON. Implementations must honour
+// `json` struct tags along with json.Marshaler and json.Unmarshaler. The codectest package contains a
+// conformance suite which any Codec should pass.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec returns the default Codec, backed by encoding/json.
+func JSONCodec() Codec {
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func ClientCodec(codec Codec) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.Codec = codec
+	}
+}
+
+func ServerCodec(codec Codec) ServerOption {
+	return func(opts *ServerOptions) {
+		opts.Codec = codec
+	}
+}
+
+// RequestCodec sets the codec used to marshal the params of a request.
+func RequestCodec(codec Codec) RequestOption {
+	return func(opts *RequestOptions) error {
+		opts.Codec = codec
+		return nil
+	}
+}
+
+// ResponseCodec sets the codec used to marshal the result of a response.
+func ResponseCodec(codec Codec) ResponseOption {
+	return func(opts *ResponseOptions) error {
+		opts.Codec = codec
+		return nil
+	}
+}
+
+type codecKey struct{}
+
+// withCodec attaches the codec of a client to the context it dials with, so that connections which inspect
+// messages can use it.
+func withCodec(ctx context.Context, codec Codec) context.Context {
+	return context.WithValue(ctx, codecKey{}, codec)
+}
+
+// contextCodec returns the codec attached by withCodec, or the default codec when there is none.
+func contextCodec(ctx context.Context) Codec {
+	if codec, ok := ctx.Value(codecKey{}).(Codec); ok {
+		return codec
+	}
+	return JSONCodec()
+}
+
+// codecOf returns the codec used by client, falling back to the default for other implementations.
+func codecOf(client Client) Codec {
+	if c, ok := client.(interface{ codec() Codec }); ok {
+		return c.codec()
+	}
+	return JSONCodec()
+}
+
+func (c *client) codec() Codec {
+	return c.opts.Codec
+}
@@ -0,0 +1,114 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
+	"github.com/41north/jsonrpc.go/codectest"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONCodec(t *testing.T) {
+	codectest.Run(t, jsonrpc.JSONCodec())
+}
+
+// countingCodec wraps the default codec, counting how often it is used.
+type countingCodec struct {
+	marshals   atomic.Int64
+	unmarshals atomic.Int64
+}
+
+func (c *countingCodec) Marshal(v any) ([]byte, error) {
+	c.marshals.Add(1)
+	return jsonrpc.JSONCodec().Marshal(v)
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v any) error {
+	c.unmarshals.Add(1)
+	return jsonrpc.JSONCodec().Unmarshal(data, v)
+}
+
+func TestClientCodec(t *testing.T) {
+	codec := &countingCodec{}
+	codectest.Run(t, codec)
+
+	client := newServerClient(t, newTestServer(), jsonrpc.ClientCodec(codec))
+
+	marshals, unmarshals := codec.marshals.Load(), codec.unmarshals.Load()
+
+	result, err := jsonrpc.Call[[]string, []string](context.Background(), client, "echo", []string{"hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hello"}, result)
+
+	// the params and request are marshalled, the message and result are unmarshalled
+	assert.Equal(t, marshals+2, codec.marshals.Load())
+	assert.Less(t, unmarshals+1, codec.unmarshals.Load())
+}
+
+func TestClientCodec_Subscription(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(jsonrpc.Response{Id: req.Id, Result: json.RawMessage(`"0xabc"`), Version: "2.0"})
+		_ = c.WriteJSON(newSubscriptionNotification("0xabc", 1))
+
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(jsonrpc.Response{Id: req.Id, Result: json.RawMessage(`true`), Version: "2.0"})
+	})
+	defer srv.Close()
+
+	codec := &countingCodec{}
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientCodec(codec))
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// the params and request are marshalled with the client codec
+	sub, err := client.Subscribe(context.Background(), "eth_subscribe", []string{"newHeads"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), codec.marshals.Load())
+
+	assert.Equal(t, json.RawMessage("1"), <-sub.Notifications())
+
+	err = sub.Unsubscribe()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), codec.marshals.Load())
+}
+
+func TestClientCodec_HTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	codec := &countingCodec{}
+	client := jsonrpc.NewClient(jsonrpc.HTTPDialer{Url: srv.URL}, jsonrpc.ClientCodec(codec))
+	assert.Nil(t, client.Connect())
+	defer client.Close()
+
+	// the connection checks with the client's codec whether the notification posted expected a response
+	unmarshals := codec.unmarshals.Load()
+	assert.Nil(t, client.Notify(context.Background(), "telemetry", nil))
+	assert.Eventually(t, func() bool { return codec.unmarshals.Load() > unmarshals }, time.Second, time.Millisecond)
+}
+
+func TestSubscriptionKeyCodec(t *testing.T) {
+	codec := &countingCodec{}
+	key := jsonrpc.SubscriptionKeyCodec(codec)
+
+	params := map[string]any{"subscription": "0xabc", "result": 1}
+	assert.Equal(t, "0xabc", key(*newRequest("eth_subscription", params)))
+	assert.Equal(t, int64(1), codec.unmarshals.Load())
+}
@@ -0,0 +1,350 @@
+// Package codectest provides a conformance suite for implementations of jsonrpc.Codec.
+package codectest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Run checks that codec marshals and unmarshals messages as encoding/json would, and that a client and server
+// configured with it can exchange requests.
+func Run(t *testing.T, codec jsonrpc.Codec) {
+	t.Run("Request", func(t *testing.T) { testRequest(t, codec) })
+	t.Run("Notification", func(t *testing.T) { testNotification(t, codec) })
+	t.Run("Response", func(t *testing.T) { testResponse(t, codec) })
+	t.Run("ErrorResponse", func(t *testing.T) { testErrorResponse(t, codec) })
+	t.Run("RawMessage", func(t *testing.T) { testRawMessage(t, codec) })
+	t.Run("Copies", func(t *testing.T) { testCopies(t, codec) })
+	t.Run("Null", func(t *testing.T) { testNull(t, codec) })
+	t.Run("Unmarshaler", func(t *testing.T) { testUnmarshaler(t, codec) })
+	t.Run("Marshaler", func(t *testing.T) { testMarshaler(t, codec) })
+	t.Run("Strings", func(t *testing.T) { testStrings(t, codec) })
+	t.Run("UnknownFields", func(t *testing.T) { testUnknownFields(t, codec) })
+	t.Run("Invalid", func(t *testing.T) { testInvalid(t, codec) })
+	t.Run("Exchange", func(t *testing.T) { testExchange(t, codec) })
+}
+
+func testRequest(t *testing.T, codec jsonrpc.Codec) {
+	req, err := jsonrpc.NewRequest("eth_getBlockByNumber", []any{"0x1", true},
+		jsonrpc.RequestNumericId(1), jsonrpc.RequestCodec(codec))
+	assert.Nil(t, err)
+
+	data, err := codec.Marshal(req)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":["0x1",true]}`, string(data))
+
+	var decoded jsonrpc.Request
+	err = codec.Unmarshal(data, &decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, "eth_getBlockByNumber", decoded.Method)
+	assert.Equal(t, "2.0", decoded.Version)
+	assert.Equal(t, json.RawMessage("1"), decoded.Id)
+	assert.JSONEq(t, `["0x1",true]`, string(decoded.Params))
+}
+
+func testNotification(t *testing.T, codec jsonrpc.Codec) {
+	req, err := jsonrpc.NewRequest("log", nil, jsonrpc.RequestCodec(codec))
+	assert.Nil(t, err)
+
+	// the id and params are omitted
+	data, err := codec.Marshal(req)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","method":"log"}`, string(data))
+
+	var decoded jsonrpc.Request
+	err = codec.Unmarshal(data, &decoded)
+	assert.Nil(t, err)
+	assert.Nil(t, decoded.Id)
+	assert.Nil(t, decoded.Params)
+}
+
+func testResponse(t *testing.T, codec jsonrpc.Codec) {
+	resp, err := jsonrpc.NewResponse(map[string]int{"number": 1},
+		jsonrpc.ResponseStringId("a"), jsonrpc.ResponseCodec(codec))
+	assert.Nil(t, err)
+
+	data, err := codec.Marshal(resp)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"a","result":{"number":1}}`, string(data))
+
+	var decoded jsonrpc.Response
+	err = codec.Unmarshal(data, &decoded)
+	assert.Nil(t, err)
+	assert.Nil(t, decoded.Error)
+	assert.Equal(t, json.RawMessage(`"a"`), decoded.Id)
+	assert.JSONEq(t, `{"number":1}`, string(decoded.Result))
+}
+
+func testErrorResponse(t *testing.T, codec jsonrpc.Codec) {
+	e := jsonrpc.ErrInvalidParams
+	e.Data = json.RawMessage(`"missing block number"`)
+
+	resp, err := jsonrpc.NewResponseError(e, jsonrpc.ResponseNumericId(2))
+	assert.Nil(t, err)
+
+	data, err := codec.Marshal(resp)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":2,"error":{"code":-32602,"message":"invalid params","data":"missing block number"}}`, string(data))
+
+	var decoded jsonrpc.Response
+	err = codec.Unmarshal(data, &decoded)
+	assert.Nil(t, err)
+	assert.Nil(t, decoded.Result)
+	assert.Equal(t, e.Code, decoded.Error.Code)
+	assert.Equal(t, e.Message, decoded.Error.Message)
+	assert.JSONEq(t, string(e.Data), string(decoded.Error.Data))
+}
+
+func testRawMessage(t *testing.T, codec jsonrpc.Codec) {
+	// raw values are preserved exactly, including numbers which do not fit in a float64
+	raw := `{"b":12345678901234567890,"a":[1.50,"x"]}`
+
+	var value struct {
+		Raw json.RawMessage `json:"raw"`
+	}
+	err := codec.Unmarshal([]byte(`{"raw":`+raw+`}`), &value)
+	assert.Nil(t, err)
+	assert.Equal(t, raw, string(value.Raw))
+
+	data, err := codec.Marshal(value)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"raw":`+raw+`}`, string(data))
+}
+
+func testCopies(t *testing.T, codec jsonrpc.Codec) {
+	// messages are read into pooled buffers which are reused, so decoded values must not reference the input
+	data := []byte(`{"jsonrpc":"2.0","id":"abc","method":"eth_call","params":[{"to":"0x1"},"latest"]}`)
+
+	var req jsonrpc.Request
+	err := codec.Unmarshal(data, &req)
+	assert.Nil(t, err)
+
+	var value struct {
+		Name string          `json:"name"`
+		Raw  json.RawMessage `json:"raw"`
+	}
+	other := []byte(`{"name":"value","raw":{"a":[1,2]}}`)
+	err = codec.Unmarshal(other, &value)
+	assert.Nil(t, err)
+
+	for i := range data {
+		data[i] = 'x'
+	}
+	for i := range other {
+		other[i] = 'x'
+	}
+
+	assert.Equal(t, "2.0", req.Version)
+	assert.Equal(t, json.RawMessage(`"abc"`), req.Id)
+	assert.Equal(t, "eth_call", req.Method)
+	assert.Equal(t, json.RawMessage(`[{"to":"0x1"},"latest"]`), req.Params)
+	assert.Equal(t, "value", value.Name)
+	assert.Equal(t, json.RawMessage(`{"a":[1,2]}`), value.Raw)
+}
+
+func testNull(t *testing.T, codec jsonrpc.Codec) {
+	// a null member is distinguishable from an absent one
+	var value struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	err := codec.Unmarshal([]byte(`{"result":null}`), &value)
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage("null"), value.Result)
+	assert.Nil(t, value.Error)
+
+	var resp jsonrpc.Response
+	err = codec.Unmarshal([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1","error":null}`), &resp)
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Error)
+}
+
+type recorder struct {
+	data string
+}
+
+func (r *recorder) UnmarshalJSON(data []byte) error {
+	r.data = string(data)
+	return nil
+}
+
+func (r recorder) MarshalJSON() ([]byte, error) {
+	return []byte(r.data), nil
+}
+
+func testUnmarshaler(t *testing.T, codec jsonrpc.Codec) {
+	var value struct {
+		Present *recorder `json:"present"`
+		Null    recorder  `json:"null"`
+	}
+	err := codec.Unmarshal([]byte(`{"present":{"a": 1},"null":null}`), &value)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"a":1}`, value.Present.data)
+	assert.Equal(t, "null", value.Null.data)
+}
+
+func testMarshaler(t *testing.T, codec jsonrpc.Codec) {
+	data, err := codec.Marshal(map[string]recorder{"value": {data: `[1,2]`}})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"value":[1,2]}`, string(data))
+}
+
+func testStrings(t *testing.T, codec jsonrpc.Codec) {
+	var value string
+	err := codec.Unmarshal([]byte(`"a\né😀"`), &value)
+	assert.Nil(t, err)
+	assert.Equal(t, "a\né😀", value)
+
+	data, err := codec.Marshal(`quote " backslash \ <tag> &`)
+	assert.Nil(t, err)
+
+	var decoded string
+	err = codec.Unmarshal(data, &decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, `quote " backslash \ <tag> &`, decoded)
+}
+
+func testUnknownFields(t *testing.T, codec jsonrpc.Codec) {
+	var req jsonrpc.Request
+	err := codec.Unmarshal([]byte(`{"jsonrpc":"2.0","id":1,"method":"ping","extra":{"a":[1]}}`), &req)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", req.Method)
+}
+
+func testInvalid(t *testing.T, codec jsonrpc.Codec) {
+	var req jsonrpc.Request
+	assert.Error(t, codec.Unmarshal([]byte(`{"jsonrpc":"2.0",`), &req))
+	assert.Error(t, codec.Unmarshal([]byte(`{"jsonrpc":2}`), &req))
+
+	_, err := codec.Marshal(make(chan int))
+	assert.Error(t, err)
+}
+
+type block struct {
+	Number string   `json:"number"`
+	Hashes []string `json:"hashes,omitempty"`
+}
+
+func testExchange(t *testing.T, codec jsonrpc.Codec) {
+	notifications := make(chan jsonrpc.Request, 1)
+
+	srv := jsonrpc.NewServer(jsonrpc.ServerCodec(codec), jsonrpc.ServerLogger(jsonrpc.NopLogger()))
+	srv.Register("echo", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		var b block
+		if err := codec.Unmarshal(req.Params, &b); err != nil {
+			return nil, jsonrpc.ErrInvalidParams
+		}
+		return b, nil
+	})
+	srv.Register("log", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		notifications <- req
+		return nil, nil
+	})
+
+	serverConn, clientConn := pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = srv.Serve(ctx, serverConn) }()
+
+	client := jsonrpc.NewClient(pipeDialer{conn: clientConn},
+		jsonrpc.ClientCodec(codec), jsonrpc.ClientLogger(jsonrpc.NopLogger()))
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	// a typed call decodes the result
+	expected := block{Number: "0x1", Hashes: []string{"0xa", "0xb"}}
+	result, err := jsonrpc.Call[block, block](ctx, client, "echo", expected)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	// errors are returned as an *Error
+	_, err = jsonrpc.Call[[]int, block](ctx, client, "echo", []int{1})
+	var rpcErr *jsonrpc.Error
+	assert.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, jsonrpc.ErrInvalidParams.Code, rpcErr.Code)
+
+	// batches are correlated
+	reqs := []jsonrpc.Request{
+		*newRequest(t, codec, "echo", block{Number: "0x2"}),
+		*newRequest(t, codec, "missing", nil),
+	}
+	resps, err := client.SendBatch(ctx, reqs)
+	assert.Nil(t, err)
+	assert.Len(t, resps, 2)
+	assert.JSONEq(t, `{"number":"0x2"}`, string(resps[0].Result))
+	assert.Equal(t, jsonrpc.ErrMethodNotFound.Code, resps[1].Error.Code)
+
+	// notifications are delivered
+	err = client.Notify(ctx, "log", []string{"hello"})
+	assert.Nil(t, err)
+	req := <-notifications
+	assert.JSONEq(t, `["hello"]`, string(req.Params))
+}
+
+func newRequest(t *testing.T, codec jsonrpc.Codec, method string, params any) *jsonrpc.Request {
+	req, err := jsonrpc.NewRequest(method, params, jsonrpc.RequestCodec(codec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// pipeConn is one end of an in-memory connection.
+type pipeConn struct {
+	in     <-chan []byte
+	out    chan<- []byte
+	closed chan struct{}
+	once   *sync.Once
+}
+
+func pipe() (*pipeConn, *pipeConn) {
+	a, b := make(chan []byte, 16), make(chan []byte, 16)
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	return &pipeConn{in: a, out: b, closed: closed, once: once},
+		&pipeConn{in: b, out: a, closed: closed, once: once}
+}
+
+func (p *pipeConn) Write(data []byte) error {
+	select {
+	case p.out <- append([]byte(nil), data...):
+		return nil
+	case <-p.closed:
+		return jsonrpc.ErrClosed
+	}
+}
+
+func (p *pipeConn) Read() ([]byte, error) {
+	select {
+	case data := <-p.in:
+		return data, nil
+	case <-p.closed:
+		return nil, jsonrpc.ErrClosed
+	}
+}
+
+func (p *pipeConn) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+type pipeDialer struct {
+	conn *pipeConn
+}
+
+func (d pipeDialer) Dial() (jsonrpc.Connection, error) {
+	return d.conn, nil
+}
+
+func (d pipeDialer) DialContext(ctx context.Context) (jsonrpc.Connection, error) {
+	return d.conn, nil
+}
@@ -1,7 +1,10 @@
 package jsonrpc
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"sync"
 )
 
 type Connection interface {
@@ -10,7 +13,50 @@ type Connection interface {
 	Close() error
 }
 
+// PooledConnection is implemented by a Connection whose Read returns buffers from a pool. Each buffer is
+// released once its message has been handled, after which it must not be retained. Of the connections
+// provided, only websocket connections pool their buffers.
+type PooledConnection interface {
+	Connection
+	Release(data []byte)
+}
+
+// WriteFailureHandler is called with a message which was written but could not be delivered, or for which no
+// response will arrive, so that any requests within it can be failed with err.
+type WriteFailureHandler = func(data []byte, err error)
+
+// AsyncConnection is implemented by a Connection whose Write returns once a message has been queued rather
+// than delivered. Failures which occur afterwards are reported to the handler, which is set before the first
+// Write.
+type AsyncConnection interface {
+	Connection
+	SetWriteFailureHandler(handler WriteFailureHandler)
+}
+
 type Dialer interface {
 	Dial() (Connection, error)
 	DialContext(ctx context.Context) (Connection, error)
 }
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+// readPooled reads r to completion into a buffer from the pool.
+func readPooled(r io.Reader) ([]byte, error) {
+	pooled := bufferPool.Get().(*[]byte)
+	buf := bytes.NewBuffer((*pooled)[:0])
+	if _, err := buf.ReadFrom(r); err != nil {
+		releasePooled(buf.Bytes())
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func releasePooled(data []byte) {
+	data = data[:0]
+	bufferPool.Put(&data)
+}
@@ -0,0 +1,224 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+type httpConnection struct {
+	url       string
+	header    http.Header
+	client    *http.Client
+	ctx       context.Context
+	cancel    context.CancelFunc
+	responses chan []byte
+	onFailure WriteFailureHandler
+	codec     Codec
+}
+
+func (h *httpConnection) SetWriteFailureHandler(handler WriteFailureHandler) {
+	h.onFailure = handler
+}
+
+// Write posts data to the endpoint in the background, so that the caller is not held for the round trip. The
+// response body is queued to be returned by Read, and any failure is reported to the WriteFailureHandler.
+func (h *httpConnection) Write(data []byte) error {
+	if h.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodPost, h.url, bytes.NewReader(data))
+	if err != nil {
+		return errors.Annotate(err, "failed to create http request")
+	}
+
+	for key, values := range h.header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	go h.post(req, data)
+	return nil
+}
+
+func (h *httpConnection) post(req *http.Request, data []byte) {
+	body, err := h.roundTrip(req, data)
+	if err != nil {
+		// everything in flight is failed when the connection is closed
+		if h.ctx.Err() == nil && h.onFailure != nil {
+			h.onFailure(data, err)
+		}
+		return
+	}
+
+	if body == nil {
+		return
+	}
+
+	select {
+	case h.responses <- body:
+	case <-h.ctx.Done():
+	}
+}
+
+// roundTrip sends req, returning the response body or nil when none is expected.
+func (h *httpConnection) roundTrip(req *http.Request, data []byte) ([]byte, error) {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, errors.Annotate(err, "http request failed")
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read http response")
+	}
+
+	body = bytes.TrimSpace(body)
+
+	if len(body) == 0 {
+		// no content is returned for notifications, but a request would otherwise never complete
+		if h.expectsResponse(data) {
+			return nil, errors.Errorf("no response body, http status: %s", resp.Status)
+		}
+		return nil, nil
+	}
+
+	// servers may return json-rpc errors with a non-2xx status, anything else is a transport failure
+	if !h.isResponseBody(body) {
+		return nil, errors.Errorf("unexpected http response, status: %s", resp.Status)
+	}
+
+	// an error with a null id, such as for a message which could not be parsed, cannot be matched by the
+	// client but is known to answer whatever was posted
+	if e, ok := h.nullIdError(body); ok {
+		return nil, e
+	}
+
+	return body, nil
+}
+
+// nullIdError returns the error within body when it is a single error response with a null id.
+func (h *httpConnection) nullIdError(body []byte) (Error, bool) {
+	kind, probe, _ := classifyMessage(h.codec, body)
+	if kind != MessageErrorResponse || !isNull(probe.Id) {
+		return Error{}, false
+	}
+
+	var e Error
+	if err := h.codec.Unmarshal(probe.Error, &e); err != nil {
+		return Error{}, false
+	}
+	return e, true
+}
+
+// expectsResponse reports whether data contains a request with an id, or a batch containing one.
+func (h *httpConnection) expectsResponse(data []byte) bool {
+	kind, _, _ := classifyMessage(h.codec, data)
+	if kind != MessageBatch {
+		return kind == MessageRequest
+	}
+
+	var elements []json.RawMessage
+	if err := h.codec.Unmarshal(data, &elements); err != nil {
+		return false
+	}
+	for _, element := range elements {
+		if kind, _, _ := classifyMessage(h.codec, element); kind == MessageRequest {
+			return true
+		}
+	}
+	return false
+}
+
+// isResponseBody reports whether body is a response, or a non-empty batch of responses.
+func (h *httpConnection) isResponseBody(body []byte) bool {
+	kind, _, _ := classifyMessage(h.codec, body)
+	if kind != MessageBatch {
+		return kind == MessageResponse || kind == MessageErrorResponse
+	}
+
+	var elements []json.RawMessage
+	if err := h.codec.Unmarshal(body, &elements); err != nil || len(elements) == 0 {
+		return false
+	}
+	for _, element := range elements {
+		if kind, _, _ := classifyMessage(h.codec, element); kind != MessageResponse && kind != MessageErrorResponse {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *httpConnection) Read() ([]byte, error) {
+	select {
+	case body := <-h.responses:
+		return body, nil
+	case <-h.ctx.Done():
+		return nil, ErrClosed
+	}
+}
+
+func (h *httpConnection) Close() error {
+	h.cancel()
+	return nil
+}
+
+// HTTPDialer creates connections which map each written message to a POST request against Url.
+type HTTPDialer struct {
+	Url           string
+	RequestHeader http.Header
+	// Client is used for all requests, when nil a client is created from TLSConfig and Timeout.
+	Client    *http.Client
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+}
+
+func (h HTTPDialer) Dial() (Connection, error) {
+	return h.DialContext(context.Background())
+}
+
+func (h HTTPDialer) DialContext(ctx context.Context) (Connection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(h.Url)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("unsupported url scheme: %s", u.Scheme)
+	}
+
+	client := h.Client
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = h.TLSConfig
+		client = &http.Client{Transport: transport, Timeout: h.Timeout}
+	}
+
+	// the connection outlives the dial context, it is only cancelled when the connection is closed
+	connCtx, cancel := context.WithCancel(context.Background())
+
+	return &httpConnection{
+		url:       u.String(),
+		header:    h.RequestHeader.Clone(),
+		client:    client,
+		ctx:       connCtx,
+		cancel:    cancel,
+		responses: make(chan []byte, 16),
+		codec:     contextCodec(ctx),
+	}, nil
+}
@@ -0,0 +1,279 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newHttpServer starts a server which responds to every request with the request method as the result.
+func newHttpServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		respond := func(req jsonrpc.Request) jsonrpc.Response {
+			return *newResponse(req.Method, func(opts *jsonrpc.ResponseOptions) error {
+				opts.Id = req.Id
+				return nil
+			})
+		}
+
+		var result any
+		if body[0] == '[' {
+			var reqs []jsonrpc.Request
+			if err := json.Unmarshal(body, &reqs); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var resps []jsonrpc.Response
+			for _, req := range reqs {
+				resps = append(resps, respond(req))
+			}
+			result = resps
+		} else {
+			var req jsonrpc.Request
+			if err := json.Unmarshal(body, &req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result = respond(req)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+}
+
+func newHttpDialer(url string) jsonrpc.HTTPDialer {
+	return jsonrpc.HTTPDialer{
+		Url:           url,
+		RequestHeader: http.Header{"X-Api-Key": []string{"secret"}},
+		Timeout:       5 * time.Second,
+	}
+}
+
+func TestHTTPDialer_InvalidUrl(t *testing.T) {
+	_, err := jsonrpc.HTTPDialer{Url: "ws://localhost"}.Dial()
+	assert.Error(t, err)
+}
+
+func TestClient_HTTPSend(t *testing.T) {
+	srv := newHttpServer()
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(newHttpDialer(srv.URL))
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	for i := 0; i < 100; i++ {
+		var resp jsonrpc.Response
+		err = client.Send(*newRequest("eth_blockNumber", nil, jsonrpc.RequestNumericId(i)), &resp)
+		assert.Nil(t, err)
+		assert.Equal(t, *newResponse("eth_blockNumber", jsonrpc.ResponseNumericId(i)), resp)
+	}
+}
+
+func TestClient_HTTPSendBatch(t *testing.T) {
+	srv := newHttpServer()
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(newHttpDialer(srv.URL))
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	reqs := []jsonrpc.Request{
+		*newRequest("eth_chainId", nil, jsonrpc.RequestNumericId(1)),
+		*newRequest("eth_blockNumber", nil, jsonrpc.RequestNumericId(2)),
+	}
+
+	resps, err := client.SendBatch(context.Background(), reqs)
+	assert.Nil(t, err)
+	assert.Equal(t, []jsonrpc.Response{
+		*newResponse("eth_chainId", jsonrpc.ResponseNumericId(1)),
+		*newResponse("eth_blockNumber", jsonrpc.ResponseNumericId(2)),
+	}, resps)
+}
+
+func TestClient_HTTPStatusError(t *testing.T) {
+	srv := newHttpServer()
+	defer srv.Close()
+
+	// the request header is omitted so the server will reject the request
+	client := jsonrpc.NewClient(jsonrpc.HTTPDialer{Url: srv.URL})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("eth_blockNumber", nil), &resp)
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestClient_HTTPNonRpcBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"message":"rate limited"}`))
+	}))
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.HTTPDialer{Url: srv.URL})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("eth_blockNumber", nil), &resp)
+	assert.ErrorContains(t, err, "429 Too Many Requests")
+}
+
+func TestClient_HTTPRpcErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonrpc.Request
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		resp, _ := jsonrpc.NewResponseError(jsonrpc.ErrMethodNotFound, jsonrpc.ResponseId(req.Id))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.HTTPDialer{Url: srv.URL})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// a json-rpc error is delivered regardless of the status
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("eth_missing", nil), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, jsonrpc.ErrMethodNotFound.Code, resp.Error.Code)
+}
+
+func TestClient_HTTPEmptyBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.HTTPDialer{Url: srv.URL})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// nothing is expected for a notification
+	err = client.Notify(context.Background(), "telemetry", nil)
+	assert.Nil(t, err)
+
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("eth_blockNumber", nil), &resp)
+	assert.ErrorContains(t, err, "no response body")
+}
+
+func TestClient_HTTPSlowResponse(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonrpc.Request
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(newResponse("pong", jsonrpc.ResponseId(req.Id)))
+	}))
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.HTTPDialer{Url: srv.URL})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// sending does not wait for the round trip
+	start := time.Now()
+	future := client.SendAsync(*newRequest("ping", nil))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// and a deadline cuts the request short
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var resp jsonrpc.Response
+	err = client.SendContext(ctx, *newRequest("ping", nil), &resp)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	close(release)
+	resp2, err := (<-future.Get()).Unwrap()
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`"pong"`), resp2.Result)
+}
+
+func TestClient_HTTPNullIdError(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+
+		// batches are answered once released, anything else is rejected without an id
+		if body[0] != '[' {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid request"}}`))
+			return
+		}
+
+		var reqs []jsonrpc.Request
+		_ = json.Unmarshal(body, &reqs)
+		<-release
+
+		var resps []*jsonrpc.Response
+		for _, req := range reqs {
+			resps = append(resps, newResponse("pong", jsonrpc.ResponseId(req.Id)))
+		}
+		_ = json.NewEncoder(w).Encode(resps)
+	}))
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.HTTPDialer{Url: srv.URL})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	batch := client.SendBatchAsync([]jsonrpc.Request{*newRequest("ping", nil), *newRequest("ping", nil)})
+
+	// the error answers the request it was posted with, not the batch in flight
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var resp jsonrpc.Response
+	err = client.SendContext(ctx, *newRequest("ping", nil), &resp)
+	assert.Equal(t, jsonrpc.ErrInvalidRequest, err)
+
+	close(release)
+	resps, err := (<-batch.Get()).Unwrap()
+	assert.Nil(t, err)
+	assert.Len(t, resps, 2)
+}
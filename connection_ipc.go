@@ -0,0 +1,73 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// ipcConnection frames messages by decoding consecutive json values from the byte stream, as there are no
+// message boundaries on a socket.
+type ipcConnection struct {
+	conn      net.Conn
+	decoder   *json.Decoder
+	writeLock sync.Mutex
+}
+
+func newIpcConnection(conn net.Conn) *ipcConnection {
+	return &ipcConnection{
+		conn:    conn,
+		decoder: json.NewDecoder(conn),
+	}
+}
+
+func (i *ipcConnection) Write(data []byte) error {
+	i.writeLock.Lock()
+	defer i.writeLock.Unlock()
+
+	if _, err := i.conn.Write(data); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return ErrClosed
+		}
+		return errors.Annotate(err, "failed to write message")
+	}
+	return nil
+}
+
+func (i *ipcConnection) Read() ([]byte, error) {
+	var msg json.RawMessage
+	if err := i.decoder.Decode(&msg); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			return nil, ErrClosed
+		}
+		// the stream cannot be re-synchronised after a decoding failure
+		return nil, errors.WithType(errors.Annotate(err, "failed to decode message"), ErrClosed)
+	}
+	return msg, nil
+}
+
+func (i *ipcConnection) Close() error {
+	return i.conn.Close()
+}
+
+// IPCDialer connects to a local unix domain socket such as the `geth.ipc` endpoint exposed by go-ethereum.
+type IPCDialer struct {
+	Path string
+}
+
+func (i IPCDialer) Dial() (Connection, error) {
+	return i.DialContext(context.Background())
+}
+
+func (i IPCDialer) DialContext(ctx context.Context) (Connection, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", i.Path)
+	if err != nil {
+		return nil, err
+	}
+	return newIpcConnection(conn), nil
+}
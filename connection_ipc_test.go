@@ -0,0 +1,96 @@
+package jsonrpc_test
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newIpcServer listens on a socket within a temporary directory, handing each accepted connection to script.
+func newIpcServer(t *testing.T, script func(conn net.Conn)) string {
+	path := filepath.Join(t.TempDir(), "test.ipc")
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				script(conn)
+			}()
+		}
+	}()
+
+	return path
+}
+
+func TestIPCDialer_Framing(t *testing.T) {
+	first, _ := json.Marshal(newResponse("first", jsonrpc.ResponseNumericId(1)))
+	second, _ := json.Marshal(newResponse("second", jsonrpc.ResponseNumericId(2)))
+	third, _ := json.Marshal(newResponse("third", jsonrpc.ResponseNumericId(3)))
+
+	path := newIpcServer(t, func(conn net.Conn) {
+		// two messages within a single write
+		_, _ = conn.Write(append(append([]byte{}, first...), second...))
+		// one message split across writes
+		_, _ = conn.Write(third[:5])
+		_, _ = conn.Write(append(third[5:], '\n'))
+	})
+
+	conn, err := jsonrpc.IPCDialer{Path: path}.Dial()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for _, expected := range [][]byte{first, second, third} {
+		msg, err := conn.Read()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, msg)
+	}
+
+	_, err = conn.Read()
+	assert.Equal(t, jsonrpc.ErrClosed, err)
+}
+
+func TestClient_IPCSend(t *testing.T) {
+	path := newIpcServer(t, func(conn net.Conn) {
+		decoder := json.NewDecoder(conn)
+		encoder := json.NewEncoder(conn)
+		for {
+			var req jsonrpc.Request
+			if err := decoder.Decode(&req); err != nil {
+				return
+			}
+			_ = encoder.Encode(jsonrpc.Response{Id: req.Id, Result: json.RawMessage(`"pong"`), Version: "2.0"})
+		}
+	})
+
+	client := jsonrpc.NewClient(jsonrpc.IPCDialer{Path: path})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	for i := 0; i < 100; i++ {
+		var resp jsonrpc.Response
+		err = client.Send(*newRequest("ping", nil, jsonrpc.RequestNumericId(i)), &resp)
+		assert.Nil(t, err)
+		assert.Equal(t, *newResponse("pong", jsonrpc.ResponseNumericId(i)), resp)
+	}
+}
+
+func TestIPCDialer_Missing(t *testing.T) {
+	_, err := jsonrpc.IPCDialer{Path: filepath.Join(t.TempDir(), "missing.ipc")}.Dial()
+	assert.Error(t, err)
+}
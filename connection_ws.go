@@ -2,55 +2,310 @@ package jsonrpc
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/juju/errors"
-	log "github.com/sirupsen/logrus"
 )
 
+// ErrPeerUnresponsive is returned from Read when nothing, not even a pong, has been received from the peer
+// within the read timeout. It satisfies errors.Is(err, ErrClosed).
+var ErrPeerUnresponsive = errors.ConstError("peer is unresponsive")
+
+// closeTimeout bounds how long sending a close frame may take.
+const closeTimeout = time.Second
+
+// webSocketOptions configures the pinging of the peer, how long it may remain silent and the queueing of
+// writes.
+type webSocketOptions struct {
+	pingInterval   time.Duration
+	pongTimeout    time.Duration
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	writeQueueSize int
+}
+
+// window returns how long the peer may be silent before it is considered dead, zero meaning indefinitely.
+func (k webSocketOptions) window() time.Duration {
+	if k.readTimeout > 0 {
+		return k.readTimeout
+	}
+	if k.pingInterval > 0 {
+		pongTimeout := k.pongTimeout
+		if pongTimeout == 0 {
+			pongTimeout = k.pingInterval
+		}
+		return k.pingInterval + pongTimeout
+	}
+	return 0
+}
+
+// QueuedConnection is implemented by a Connection which queues writes, reporting how many are waiting to
+// be written or in progress.
+type QueuedConnection interface {
+	Connection
+	WriteQueueDepth() int
+}
+
+// webSocketWrite is a message queued for the writer, which reports the outcome on done.
+type webSocketWrite struct {
+	data []byte
+	done chan error
+}
+
 type webSocketConnection struct {
-	conn *websocket.Conn
+	conn      *websocket.Conn
+	log       Logger
+	opts      webSocketOptions
+	writes    chan webSocketWrite
+	queued    atomic.Int64
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWebSocketConnection wraps an established websocket connection, such as one which has been upgraded
+// by a server, as a Connection.
+func NewWebSocketConnection(conn *websocket.Conn) Connection {
+	return newWebSocketConnection(conn, defaultLogger(), webSocketOptions{})
+}
+
+// NewWebSocketConnectionWithLogger is the same as NewWebSocketConnection, logging to logger rather than the
+// logrus standard logger.
+func NewWebSocketConnectionWithLogger(conn *websocket.Conn, logger Logger) Connection {
+	if logger == nil {
+		logger = NopLogger()
+	}
+	return newWebSocketConnection(conn, logger, webSocketOptions{})
 }
 
+func newWebSocketConnection(conn *websocket.Conn, logger Logger, opts webSocketOptions) *webSocketConnection {
+	if opts.writeQueueSize <= 0 {
+		opts.writeQueueSize = 64
+	}
+
+	w := &webSocketConnection{
+		conn:    conn,
+		log:     logger.With("remoteAddr", conn.RemoteAddr().String()),
+		opts:    opts,
+		writes:  make(chan webSocketWrite, opts.writeQueueSize),
+		closing: make(chan struct{}),
+	}
+
+	// gorilla supports a single concurrent writer, so all messages are written from one goroutine
+	go w.writeLoop()
+
+	if opts.window() > 0 {
+		// any control frame from the peer shows that it is still alive
+		conn.SetPongHandler(func(string) error {
+			return w.extendReadDeadline()
+		})
+		conn.SetPingHandler(func(data string) error {
+			if err := w.extendReadDeadline(); err != nil {
+				return err
+			}
+			err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(w.opts.window()))
+			if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+				w.log.Debug("failed to send pong", "error", err)
+			}
+			return nil
+		})
+	}
+
+	if opts.pingInterval > 0 {
+		go w.ping()
+	}
+
+	return w
+}
+
+// ping periodically pings the peer until the connection is closed. A failure to ping is detected by the read
+// deadline, so is only logged.
+func (w *webSocketConnection) ping() {
+	ticker := time.NewTicker(w.opts.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closing:
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(w.opts.pingInterval)
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				w.log.Debug("failed to send ping", "error", err)
+			}
+		}
+	}
+}
+
+func (w *webSocketConnection) extendReadDeadline() error {
+	window := w.opts.window()
+	if window == 0 {
+		return nil
+	}
+	return w.conn.SetReadDeadline(time.Now().Add(window))
+}
+
+// Write queues data for the writer and waits for it to be written. Whilst the queue is full Write blocks.
 func (w *webSocketConnection) Write(data []byte) error {
-	return w.conn.WriteMessage(websocket.TextMessage, data)
+	write := webSocketWrite{data: data, done: make(chan error, 1)}
+
+	w.queued.Add(1)
+	select {
+	case w.writes <- write:
+	case <-w.closing:
+		w.queued.Add(-1)
+		return ErrClosed
+	}
+
+	select {
+	case err := <-write.done:
+		return err
+	case <-w.closing:
+		return ErrClosed
+	}
 }
 
-func (w *webSocketConnection) Read() ([]byte, error) {
-	msgType, bytes, err := w.conn.ReadMessage()
-	if err != nil {
+func (w *webSocketConnection) WriteQueueDepth() int {
+	return int(w.queued.Load())
+}
 
-		log.WithError(err).Error("read failure")
+func (w *webSocketConnection) writeLoop() {
+	for {
+		select {
+		case <-w.closing:
+			return
+		case write := <-w.writes:
+			write.done <- w.writeMessage(write.data)
+			w.queued.Add(-1)
+		}
+	}
+}
+
+func (w *webSocketConnection) writeMessage(data []byte) error {
+	if timeout := w.opts.writeTimeout; timeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return errors.WithType(err, ErrClosed)
+		}
+	}
 
-		switch err.(type) {
-		case *websocket.CloseError:
-			// re-map error
-			return nil, ErrClosed
-		default:
-			return nil, err
+	if err := w.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return ErrClosed
 		}
+		// gorilla does not recover from a failed write, including one which timed out
+		return errors.WithType(errors.Annotate(err, "failed to write message"), ErrClosed)
+	}
+	return nil
+}
+
+// Read returns the next message in a pooled buffer, see PooledConnection.
+func (w *webSocketConnection) Read() ([]byte, error) {
+	if err := w.extendReadDeadline(); err != nil {
+		return nil, w.readError(err)
+	}
+
+	msgType, reader, err := w.conn.NextReader()
+	if err != nil {
+		return nil, w.readError(err)
 	}
 
 	if msgType != websocket.TextMessage {
 		return nil, errors.Errorf("expected text message type, received a writer for %v", msgType)
 	}
 
+	bytes, err := readPooled(reader)
 	if err != nil {
-		return nil, errors.Annotate(err, "failed to read message")
+		return nil, w.readError(err)
 	}
 
 	return bytes, nil
 }
 
+func (w *webSocketConnection) readError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		w.log.Warn("peer is unresponsive", "timeout", w.opts.window())
+		return errors.WithType(ErrPeerUnresponsive, ErrClosed)
+	}
+
+	switch e := err.(type) {
+	case *websocket.CloseError:
+		w.log.Debug("connection closed", "code", e.Code, "text", e.Text)
+		// re-map error
+		return ErrClosed
+	default:
+		w.log.Warn("read failure", "error", err)
+		// any other read failure is permanent for the underlying connection
+		return errors.WithType(err, ErrClosed)
+	}
+}
+
+func (w *webSocketConnection) Release(data []byte) {
+	releasePooled(data)
+}
+
+// Close sends a close frame to the peer before closing the underlying connection.
 func (w *webSocketConnection) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closing)
+
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		err := w.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout))
+		if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+			w.log.Debug("failed to send close frame", "error", err)
+		}
+	})
 	return w.conn.Close()
 }
 
+// HandshakeHandler is called with the response to each successful websocket handshake.
+type HandshakeHandler = func(resp *http.Response)
+
 type WebSocketDialer struct {
 	Url           string
 	RequestHeader http.Header
-	// TODO expose more of the underlying ws options
+	// Logger is used by connections created with this dialer. When nil the logger of the client dialling is
+	// used, falling back to the logrus standard logger when dialled directly.
+	Logger Logger
+
+	// TLSClientConfig is used for wss urls, allowing client certificates to be presented.
+	TLSClientConfig *tls.Config
+	// Proxy returns the proxy for a request, when nil no proxy is used.
+	Proxy            func(*http.Request) (*url.URL, error)
+	Subprotocols     []string
+	HandshakeTimeout time.Duration
+	// ReadBufferSize and WriteBufferSize are the sizes of the I/O buffers, when zero a default of 4096 is used.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// EnableCompression negotiates permessage-deflate with the server. CompressionLevel optionally sets the
+	// flate level used for writes.
+	EnableCompression bool
+	CompressionLevel  int
+	// ReadLimit is the maximum size in bytes of a message read from the server, larger messages close the
+	// connection. Zero means no limit.
+	ReadLimit int64
+
+	// PingInterval is how often the server is pinged, zero disables pinging. PongTimeout is how long after
+	// the interval a pong may take to arrive before the connection fails with ErrPeerUnresponsive, defaulting
+	// to PingInterval.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+	// ReadTimeout is the longest the server may be silent before the connection fails with
+	// ErrPeerUnresponsive, pongs included. When pinging it defaults to PingInterval + PongTimeout.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds how long writing a message may take, zero means no limit. WriteQueueSize is how
+	// many messages may wait to be written before further writes block, defaulting to 64.
+	WriteTimeout   time.Duration
+	WriteQueueSize int
+
+	// HandshakeHandler is called after each successful handshake, including those made when reconnecting.
+	HandshakeHandler HandshakeHandler
 }
 
 func (w WebSocketDialer) Dial() (Connection, error) {
@@ -58,8 +313,58 @@ func (w WebSocketDialer) Dial() (Connection, error) {
 }
 
 func (w WebSocketDialer) DialContext(ctx context.Context) (Connection, error) {
-	dialer := websocket.Dialer{}
-	wsConn, _, err := dialer.DialContext(ctx, w.Url, w.RequestHeader)
-	conn := webSocketConnection{conn: wsConn}
-	return &conn, err
+	conn, _, err := w.DialHandshake(ctx)
+	return conn, err
+}
+
+// DialHandshake connects in the same way as DialContext, additionally returning the handshake response. The
+// response is also returned when the handshake fails, allowing its status and headers to be inspected.
+func (w WebSocketDialer) DialHandshake(ctx context.Context) (Connection, *http.Response, error) {
+	dialer := websocket.Dialer{
+		Proxy:             w.Proxy,
+		TLSClientConfig:   w.TLSClientConfig,
+		HandshakeTimeout:  w.HandshakeTimeout,
+		ReadBufferSize:    w.ReadBufferSize,
+		WriteBufferSize:   w.WriteBufferSize,
+		Subprotocols:      w.Subprotocols,
+		EnableCompression: w.EnableCompression,
+	}
+
+	wsConn, resp, err := dialer.DialContext(ctx, w.Url, w.RequestHeader)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			err = errors.Annotatef(err, "handshake failed with status %s", resp.Status)
+		}
+		return nil, resp, err
+	}
+
+	if w.CompressionLevel != 0 {
+		if err := wsConn.SetCompressionLevel(w.CompressionLevel); err != nil {
+			_ = wsConn.Close()
+			return nil, resp, errors.Annotate(err, "failed to set compression level")
+		}
+	}
+
+	if w.ReadLimit > 0 {
+		wsConn.SetReadLimit(w.ReadLimit)
+	}
+
+	if w.HandshakeHandler != nil {
+		w.HandshakeHandler(resp)
+	}
+
+	logger := w.Logger
+	if logger == nil {
+		logger = contextLogger(ctx)
+	}
+
+	opts := webSocketOptions{
+		pingInterval:   w.PingInterval,
+		pongTimeout:    w.PongTimeout,
+		readTimeout:    w.ReadTimeout,
+		writeTimeout:   w.WriteTimeout,
+		writeQueueSize: w.WriteQueueSize,
+	}
+
+	return newWebSocketConnection(wsConn, logger, opts), resp, nil
 }
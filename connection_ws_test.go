@@ -1,13 +1,25 @@
 package jsonrpc_test
 
 import (
+	"compress/flate"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
 
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 )
 
 var upgrader = websocket.Upgrader{}
@@ -83,7 +95,7 @@ func (t *wsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			if t.closeOnNextMessage.Load() {
+			if t.closeOnNextMessage.CompareAndSwap(true, false) {
 				return
 			}
 
@@ -96,3 +108,281 @@ func (t *wsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// newScriptedWsServer starts a server which hands each upgraded connection to script, allowing tests to
+// control the exact sequence of messages exchanged.
+func newScriptedWsServer(script func(c *websocket.Conn)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		script(c)
+	}))
+}
+
+func wsUrl(srv *httptest.Server) string {
+	return strings.Replace(srv.URL, "http", "ws", 1)
+}
+
+func TestWebSocketDialer_Options(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"jsonrpc"}, EnableCompression: true}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_ = c.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 1024)))
+		_, _, _ = c.ReadMessage()
+	}))
+	defer srv.Close()
+
+	var handshake *http.Response
+	proxied := false
+
+	dialer := jsonrpc.WebSocketDialer{
+		Url: wsUrl(srv),
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			proxied = true
+			return nil, nil
+		},
+		Subprotocols:      []string{"jsonrpc"},
+		HandshakeTimeout:  time.Second,
+		ReadBufferSize:    512,
+		WriteBufferSize:   512,
+		EnableCompression: true,
+		CompressionLevel:  flate.BestSpeed,
+		HandshakeHandler: func(resp *http.Response) {
+			handshake = resp
+		},
+	}
+
+	conn, resp, err := dialer.DialHandshake(context.Background())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.True(t, proxied)
+	assert.Same(t, resp, handshake)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "jsonrpc", resp.Header.Get("Sec-Websocket-Protocol"))
+	assert.Contains(t, resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+
+	data, err := conn.Read()
+	assert.Nil(t, err)
+	assert.Len(t, data, 1024)
+}
+
+func TestWebSocketDialer_ReadLimit(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		_ = c.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 1024)))
+		_, _, _ = c.ReadMessage()
+	})
+	defer srv.Close()
+
+	dialer := jsonrpc.WebSocketDialer{Url: wsUrl(srv), ReadLimit: 512, Logger: jsonrpc.NopLogger()}
+	conn, err := dialer.Dial()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Read()
+	assert.ErrorIs(t, err, jsonrpc.ErrClosed)
+}
+
+func TestWebSocketDialer_TLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _, _ = c.ReadMessage()
+	}))
+	defer srv.Close()
+
+	// the server certificate is not trusted by default
+	_, err := jsonrpc.WebSocketDialer{Url: wsUrl(srv)}.Dial()
+	assert.Error(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	conn, err := jsonrpc.WebSocketDialer{Url: wsUrl(srv), TLSClientConfig: &tls.Config{RootCAs: roots}}.Dial()
+	assert.Nil(t, err)
+	_ = conn.Close()
+}
+
+func TestWebSocketDialer_BadHandshake(t *testing.T) {
+	srv := newWsServer(false)
+	defer srv.close()
+
+	dialer := jsonrpc.WebSocketDialer{Url: srv.url("/")}
+	_, resp, err := dialer.DialHandshake(context.Background())
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Contains(t, err.Error(), "200 OK")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestWebSocketDialer_Keepalive(t *testing.T) {
+	var pings atomic.Int32
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		c.SetPingHandler(func(data string) error {
+			pings.Add(1)
+			return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		// pings are only answered whilst reading
+		_, _, _ = c.ReadMessage()
+	})
+	defer srv.Close()
+
+	dialer := jsonrpc.WebSocketDialer{Url: wsUrl(srv), PingInterval: 20 * time.Millisecond, PongTimeout: 50 * time.Millisecond}
+	conn, err := dialer.Dial()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Read()
+		errs <- err
+	}()
+
+	// an idle connection is kept alive by pongs
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected read error: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	assert.Greater(t, pings.Load(), int32(5))
+}
+
+func TestWebSocketDialer_ReadTimeout(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		time.Sleep(time.Second)
+	})
+	defer srv.Close()
+
+	dialer := jsonrpc.WebSocketDialer{Url: wsUrl(srv), ReadTimeout: 50 * time.Millisecond, Logger: jsonrpc.NopLogger()}
+	conn, err := dialer.Dial()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Read()
+	assert.ErrorIs(t, err, jsonrpc.ErrPeerUnresponsive)
+	assert.ErrorIs(t, err, jsonrpc.ErrClosed)
+}
+
+func TestClient_PeerUnresponsive(t *testing.T) {
+	requests := make(chan struct{}, 1)
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		// read the request but never answer it, nor any pings
+		c.SetPingHandler(func(string) error { return nil })
+		_, _, _ = c.ReadMessage()
+		requests <- struct{}{}
+		time.Sleep(time.Second)
+	})
+	defer srv.Close()
+
+	dialer := jsonrpc.WebSocketDialer{
+		Url:          wsUrl(srv),
+		PingInterval: 20 * time.Millisecond,
+		PongTimeout:  20 * time.Millisecond,
+		Logger:       jsonrpc.NopLogger(),
+	}
+	client := jsonrpc.NewClient(dialer)
+
+	closed := make(chan error, 1)
+	client.SetCloseHandler(func(err error) {
+		closed <- err
+	})
+
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	// the pending request fails rather than hanging
+	future := client.SendAsync(*newRequest("eth_blockNumber", nil))
+	<-requests
+
+	_, err = (<-future.Get()).Unwrap()
+	assert.ErrorIs(t, err, jsonrpc.ErrClosed)
+
+	err = <-closed
+	assert.ErrorIs(t, err, jsonrpc.ErrPeerUnresponsive)
+}
+
+func TestWebSocketConnection_ConcurrentWrites(t *testing.T) {
+	client := newServerClient(t, newTestServer())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			params := []string{strconv.Itoa(i)}
+			result, err := jsonrpc.Call[[]string, []string](context.Background(), client, "echo", params)
+			assert.Nil(t, err)
+			assert.Equal(t, params, result)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWebSocketConnection_WriteQueue(t *testing.T) {
+	release := make(chan struct{})
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		// stop reading so that writes back up
+		<-release
+	})
+	defer srv.Close()
+	defer close(release)
+
+	dialer := jsonrpc.WebSocketDialer{Url: wsUrl(srv), WriteTimeout: 100 * time.Millisecond, Logger: jsonrpc.NopLogger()}
+	conn, err := dialer.Dial()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	queued := conn.(jsonrpc.QueuedConnection)
+	data := []byte(`"` + strings.Repeat("a", 1<<20) + `"`)
+
+	// writes eventually time out once the socket buffers are full
+	errs := make(chan error, 64)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- conn.Write(data) }()
+	}
+
+	assert.Eventually(t, func() bool {
+		return queued.WriteQueueDepth() > 1
+	}, time.Second, time.Millisecond)
+
+	var failed error
+	for i := 0; i < cap(errs) && failed == nil; i++ {
+		failed = <-errs
+	}
+	assert.ErrorIs(t, failed, jsonrpc.ErrClosed)
+}
+
+func TestClient_Stats(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		// read requests without replying
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	for i := 0; i < 3; i++ {
+		client.SendAsync(*newRequest("ping", nil))
+	}
+
+	stats := client.Stats()
+	assert.Equal(t, 3, stats.InFlight)
+	assert.Equal(t, 0, stats.WriteQueueDepth)
+}
@@ -0,0 +1,275 @@
+package jsonrpc
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Dispatcher determines how incoming requests are handed to the request handler. Dispatch is called on the
+// read loop, so a Dispatcher which blocks applies back pressure to the connection, delaying the delivery of
+// responses until it returns.
+type Dispatcher interface {
+	Dispatch(req Request, handle func(req Request))
+	Stats() DispatcherStats
+
+	// Close stops the dispatcher accepting further requests. Requests which have already been dispatched
+	// are still handled.
+	Close()
+}
+
+type DispatcherStats struct {
+	// Queued is the number of requests waiting to be handled.
+	Queued int64
+	// Active is the number of requests currently being handled.
+	Active int64
+	// Handled is the total number of requests which have been handled.
+	Handled int64
+	// Blocked is the number of times Dispatch had to wait for capacity, stalling the read loop.
+	Blocked int64
+}
+
+type dispatcherStats struct {
+	queued  atomic.Int64
+	active  atomic.Int64
+	handled atomic.Int64
+	blocked atomic.Int64
+}
+
+func (s *dispatcherStats) Stats() DispatcherStats {
+	return DispatcherStats{
+		Queued:  s.queued.Load(),
+		Active:  s.active.Load(),
+		Handled: s.handled.Load(),
+		Blocked: s.blocked.Load(),
+	}
+}
+
+func (s *dispatcherStats) handle(req Request, handle func(req Request)) {
+	s.active.Add(1)
+	defer func() {
+		s.active.Add(-1)
+		s.handled.Add(1)
+	}()
+	handle(req)
+}
+
+// InlineDispatcher handles each request on the read loop, in the order received. A handler must not wait
+// on a response from the same client, as the response cannot be read until the handler returns.
+func InlineDispatcher() Dispatcher {
+	return &inlineDispatcher{}
+}
+
+type inlineDispatcher struct {
+	dispatcherStats
+	closed atomic.Bool
+}
+
+func (d *inlineDispatcher) Dispatch(req Request, handle func(req Request)) {
+	if d.closed.Load() {
+		return
+	}
+	d.handle(req, handle)
+}
+
+func (d *inlineDispatcher) Close() {
+	d.closed.Store(true)
+}
+
+type dispatch struct {
+	req    Request
+	handle func(req Request)
+}
+
+// PoolDispatcher handles requests concurrently with a fixed number of workers. Up to queueSize requests wait
+// for a free worker before Dispatch blocks. Requests are not handled in any particular order. A request still
+// waiting for capacity when the dispatcher is closed is dropped.
+func PoolDispatcher(workers int, queueSize int) Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &poolDispatcher{
+		workers: workers,
+		queue:   make(chan dispatch, queueSize),
+		closing: make(chan struct{}),
+	}
+}
+
+type poolDispatcher struct {
+	dispatcherStats
+	workers int
+	queue   chan dispatch
+	start   sync.Once
+	lock    sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	// senders tracks the calls to Dispatch which may still send to the queue
+	senders sync.WaitGroup
+}
+
+func (d *poolDispatcher) Dispatch(req Request, handle func(req Request)) {
+	d.lock.RLock()
+	if d.closed {
+		d.lock.RUnlock()
+		return
+	}
+	d.senders.Add(1)
+	d.lock.RUnlock()
+	defer d.senders.Done()
+
+	// workers are started lazily so that an unused dispatcher does not leak goroutines
+	d.start.Do(func() {
+		for i := 0; i < d.workers; i++ {
+			go d.work()
+		}
+	})
+
+	d.queued.Add(1)
+	item := dispatch{req: req, handle: handle}
+	select {
+	case d.queue <- item:
+		return
+	default:
+	}
+
+	// the lock is not held whilst waiting for capacity, so that a slow handler cannot hold up Close
+	d.blocked.Add(1)
+	select {
+	case d.queue <- item:
+	case <-d.closing:
+		d.queued.Add(-1)
+	}
+}
+
+func (d *poolDispatcher) work() {
+	for item := range d.queue {
+		d.queued.Add(-1)
+		d.handle(item.req, item.handle)
+	}
+}
+
+func (d *poolDispatcher) Close() {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.closing)
+	d.lock.Unlock()
+
+	// any Dispatch waiting for capacity gives up once closing is closed, after which nothing else can send
+	d.senders.Wait()
+	close(d.queue)
+}
+
+// OrderedDispatcher handles requests with the same key in the order received, whilst requests with
+// different keys are handled concurrently, at most concurrency at a time. Up to queueSize requests wait to
+// be handled before Dispatch blocks.
+func OrderedDispatcher(concurrency int, queueSize int, key func(req Request) string) Dispatcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &orderedDispatcher{
+		key:     key,
+		workers: make(chan struct{}, concurrency),
+		slots:   make(chan struct{}, concurrency+queueSize),
+		queues:  make(map[string][]dispatch),
+	}
+}
+
+type orderedDispatcher struct {
+	dispatcherStats
+	key     func(req Request) string
+	workers chan struct{}
+	// slots bounds the number of requests which are queued or being handled
+	slots  chan struct{}
+	lock   sync.Mutex
+	queues map[string][]dispatch
+	closed bool
+}
+
+func (d *orderedDispatcher) Dispatch(req Request, handle func(req Request)) {
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		d.blocked.Add(1)
+		d.slots <- struct{}{}
+	}
+
+	key := d.key(req)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		<-d.slots
+		return
+	}
+
+	d.queued.Add(1)
+
+	// a key is present whilst a goroutine is working through its queue
+	queue, running := d.queues[key]
+	d.queues[key] = append(queue, dispatch{req: req, handle: handle})
+	if !running {
+		go d.work(key)
+	}
+}
+
+func (d *orderedDispatcher) work(key string) {
+	for {
+		d.lock.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.lock.Unlock()
+			return
+		}
+		item := queue[0]
+		d.queues[key] = queue[1:]
+		d.lock.Unlock()
+
+		d.workers <- struct{}{}
+		d.queued.Add(-1)
+		d.handle(item.req, item.handle)
+		<-d.workers
+		<-d.slots
+	}
+}
+
+func (d *orderedDispatcher) Close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closed = true
+}
+
+// SubscriptionKey is a key for OrderedDispatcher which orders `*_subscription` notifications by subscription
+// id, and any other request by method.
+func SubscriptionKey(req Request) string {
+	return subscriptionKey(JSONCodec(), req)
+}
+
+// SubscriptionKeyCodec is the same as SubscriptionKey, decoding notification params with codec, which should be
+// that of the client.
+func SubscriptionKeyCodec(codec Codec) func(req Request) string {
+	return func(req Request) string {
+		return subscriptionKey(codec, req)
+	}
+}
+
+func subscriptionKey(codec Codec, req Request) string {
+	if strings.HasSuffix(req.Method, "_subscription") {
+		var params subscriptionParams
+		if err := codec.Unmarshal(req.Params, &params); err == nil && params.Subscription != nil {
+			return subscriptionId(codec, params.Subscription)
+		}
+	}
+	return req.Method
+}
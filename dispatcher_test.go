@@ -0,0 +1,235 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInlineDispatcher(t *testing.T) {
+	dispatcher := jsonrpc.InlineDispatcher()
+
+	var methods []string
+	handle := func(req jsonrpc.Request) { methods = append(methods, req.Method) }
+
+	dispatcher.Dispatch(*newRequest("a", nil), handle)
+	dispatcher.Dispatch(*newRequest("b", nil), handle)
+	assert.Equal(t, []string{"a", "b"}, methods)
+	assert.Equal(t, jsonrpc.DispatcherStats{Handled: 2}, dispatcher.Stats())
+
+	// requests are dropped once closed
+	dispatcher.Close()
+	dispatcher.Dispatch(*newRequest("c", nil), handle)
+	assert.Equal(t, []string{"a", "b"}, methods)
+}
+
+func TestPoolDispatcher(t *testing.T) {
+	dispatcher := jsonrpc.PoolDispatcher(2, 1)
+	defer dispatcher.Close()
+
+	started := make(chan string, 4)
+	unblock := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	handle := func(req jsonrpc.Request) {
+		defer wg.Done()
+		started <- req.Method
+		<-unblock
+	}
+
+	// two requests occupy the workers and one waits in the queue
+	dispatcher.Dispatch(*newRequest("a", nil), handle)
+	dispatcher.Dispatch(*newRequest("b", nil), handle)
+	<-started
+	<-started
+	dispatcher.Dispatch(*newRequest("c", nil), handle)
+
+	stats := dispatcher.Stats()
+	assert.Equal(t, int64(2), stats.Active)
+	assert.Equal(t, int64(1), stats.Queued)
+
+	// a fourth request blocks until there is capacity
+	blocked := stats.Blocked
+	dispatched := make(chan struct{})
+	go func() {
+		dispatcher.Dispatch(*newRequest("d", nil), handle)
+		close(dispatched)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return dispatcher.Stats().Blocked == blocked+1
+	}, time.Second, 10*time.Millisecond)
+
+	close(unblock)
+	<-dispatched
+	wg.Wait()
+
+	assert.Equal(t, int64(4), dispatcher.Stats().Handled)
+}
+
+func TestPoolDispatcher_CloseWhilstBlocked(t *testing.T) {
+	dispatcher := jsonrpc.PoolDispatcher(1, 0)
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	dispatcher.Dispatch(*newRequest("a", nil), func(jsonrpc.Request) {
+		close(started)
+		<-unblock
+	})
+	<-started
+	blocked := dispatcher.Stats().Blocked
+
+	// the only worker is busy, so the next request waits for capacity
+	dispatched := make(chan struct{})
+	go func() {
+		dispatcher.Dispatch(*newRequest("b", nil), func(jsonrpc.Request) {})
+		close(dispatched)
+	}()
+	assert.Eventually(t, func() bool { return dispatcher.Stats().Blocked == blocked+1 }, time.Second, time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		dispatcher.Close()
+		close(closed)
+	}()
+
+	for _, done := range []chan struct{}{closed, dispatched} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("close waited on the busy worker")
+		}
+	}
+	assert.Equal(t, int64(0), dispatcher.Stats().Queued)
+}
+
+func TestOrderedDispatcher(t *testing.T) {
+	dispatcher := jsonrpc.OrderedDispatcher(4, 64, func(req jsonrpc.Request) string {
+		return req.Method
+	})
+	defer dispatcher.Close()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	received := make(map[string][]int)
+
+	handle := func(req jsonrpc.Request) {
+		defer wg.Done()
+		var n int
+		_ = req.UnmarshalParams(&n)
+
+		lock.Lock()
+		defer lock.Unlock()
+		received[req.Method] = append(received[req.Method], n)
+	}
+
+	for i := 0; i < 20; i++ {
+		for _, method := range []string{"a", "b", "c"} {
+			wg.Add(1)
+			dispatcher.Dispatch(*newRequest(method, i), handle)
+		}
+	}
+	wg.Wait()
+
+	// each key is handled in order
+	for _, method := range []string{"a", "b", "c"} {
+		assert.Len(t, received[method], 20)
+		for i, n := range received[method] {
+			assert.Equal(t, i, n)
+		}
+	}
+
+	assert.Equal(t, int64(60), dispatcher.Stats().Handled)
+}
+
+func TestSubscriptionKey(t *testing.T) {
+	params := map[string]any{"subscription": "0xabc", "result": 1}
+	assert.Equal(t, "0xabc", jsonrpc.SubscriptionKey(*newRequest("eth_subscription", params)))
+	assert.Equal(t, "eth_chainId", jsonrpc.SubscriptionKey(*newRequest("eth_chainId", nil)))
+}
+
+func TestClient_Dispatcher(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		for i := 0; i < 10; i++ {
+			params := map[string]any{"subscription": "0x1", "result": i}
+			_ = c.WriteJSON(newRequest("eth_subscription", params))
+		}
+
+		// wait for the client to go away
+		_, _, _ = c.ReadMessage()
+	})
+	defer srv.Close()
+
+	dispatcher := jsonrpc.OrderedDispatcher(2, 16, jsonrpc.SubscriptionKey)
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientDispatcher(dispatcher))
+
+	results := make(chan int, 10)
+	client.SetRequestHandler(func(req jsonrpc.Request) {
+		var params struct{ Result int }
+		_ = req.UnmarshalParams(&params)
+		results <- params.Result
+	})
+
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, i, <-results)
+	}
+
+	err = client.Close()
+	assert.Nil(t, err)
+
+	// the dispatcher belongs to the caller, so outlives the client
+	handled := make(chan struct{})
+	dispatcher.Dispatch(*newRequest("ping", nil), func(jsonrpc.Request) { close(handled) })
+	<-handled
+	dispatcher.Close()
+}
+
+func TestClient_CloseSaturatedDispatcher(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		for i := 0; i < 3; i++ {
+			_ = c.WriteJSON(newRequest("wait", nil, jsonrpc.RequestNumericId(i)))
+		}
+		_, _, _ = c.ReadMessage()
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientHandlerConcurrency(2))
+
+	started := make(chan struct{}, 3)
+	client.SetReplyHandler(func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	// both workers are busy, so the third request blocks the read loop within the dispatcher
+	<-started
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		_ = client.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("close blocked on the dispatcher")
+	}
+}
@@ -0,0 +1,102 @@
+package jsonrpc
+
+import (
+	"context"
+
+	"github.com/juju/errors"
+)
+
+// PoolFailoverCodes sets the response error codes which cause a connection to be ejected, such as
+// ErrInternal or a provider's rate limit code. An ejected connection is restored by the next health check
+// it passes.
+func PoolFailoverCodes(codes ...int32) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.FailoverCodes = codes
+	}
+}
+
+// PoolRetries sets how many times a request is retried with another connection after its connection fails
+// or returns a failover code. Only requests accepted by PoolIdempotent are retried.
+func PoolRetries(retries int) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.Retries = retries
+	}
+}
+
+// PoolIdempotent sets the predicate deciding which requests may safely be sent more than once. A batch is
+// only retried when every request within it is idempotent.
+func PoolIdempotent(idempotent func(req Request) bool) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.Idempotent = idempotent
+	}
+}
+
+// IdempotentMethods returns a predicate for PoolIdempotent accepting requests for any of the given methods.
+func IdempotentMethods(methods ...string) func(req Request) bool {
+	set := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		set[method] = struct{}{}
+	}
+	return func(req Request) bool {
+		_, ok := set[req.Method]
+		return ok
+	}
+}
+
+// NewFailoverClient creates a PoolClient which sends every request to the first healthy endpoint in the
+// order of the dialers. An endpoint is ejected when its connection fails or it returns ErrInternal, moving
+// requests on to the next endpoint, and requests accepted by idempotent are retried there. An ejected endpoint
+// is restored, and requests return to it, once its connection has been re-established and it passes check.
+//
+// Both depend on the methods being called, for example checking a node with eth_chainId and retrying reads
+// accepted by IdempotentMethods. A nil idempotent retries nothing. check should not be nil, as without it an
+// endpoint ejected for an error code is restored on the next health interval without being probed.
+//
+// The defaults may be overridden with further options, for example adding rate limit codes with
+// PoolFailoverCodes.
+func NewFailoverClient(
+	dialers []Dialer,
+	check HealthCheck,
+	idempotent func(req Request) bool,
+	options ...PoolOption,
+) *PoolClient {
+	defaults := []PoolOption{
+		PoolBalancing(Failover),
+		PoolMaxFailures(1),
+		PoolFailoverCodes(ErrInternal.Code),
+		PoolRetries(len(dialers) - 1),
+		PoolHealthCheck(check),
+		PoolIdempotent(idempotent),
+	}
+	return NewPoolClient(dialers, append(defaults, options...)...)
+}
+
+// FailoverDialer dials each of its dialers in order, returning the first connection established. Used with
+// a ReconnectPolicy, a lost connection is replaced with one to the earliest endpoint available.
+type FailoverDialer struct {
+	Dialers []Dialer
+}
+
+func (f FailoverDialer) Dial() (Connection, error) {
+	return f.DialContext(context.Background())
+}
+
+func (f FailoverDialer) DialContext(ctx context.Context) (Connection, error) {
+	var lastErr error
+	for _, dialer := range f.Dialers {
+		conn, err := dialer.DialContext(ctx)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if lastErr == nil {
+		return nil, errors.New("no dialers to fail over between")
+	}
+	return nil, errors.Annotate(lastErr, "failed to dial any endpoint")
+}
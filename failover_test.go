@@ -0,0 +1,128 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyCheck probes an endpoint with the method which fails whilst the server is failing.
+func flakyCheck(ctx context.Context, c jsonrpc.Client) error {
+	_, err := jsonrpc.Call[any, string](ctx, c, "flaky", nil)
+	return err
+}
+
+func newFailoverClient(
+	t *testing.T,
+	servers []*poolServer,
+	idempotent func(req jsonrpc.Request) bool,
+	options ...jsonrpc.PoolOption,
+) *jsonrpc.PoolClient {
+	var dialers []jsonrpc.Dialer
+	for _, srv := range servers {
+		dialers = append(dialers, srv.dialer())
+	}
+
+	client := jsonrpc.NewFailoverClient(dialers, flakyCheck, idempotent, options...)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestFailoverClient_ConnectionLost(t *testing.T) {
+	primary, backup := newPoolServer(t, "primary"), newPoolServer(t, "backup")
+	client := newFailoverClient(t, []*poolServer{primary, backup}, nil, jsonrpc.PoolHealthInterval(20*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "primary", whoami(t, client))
+	}
+
+	primary.drop()
+	assert.Eventually(t, func() bool { return !client.Members()[0].Healthy }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "backup", whoami(t, client))
+
+	// the primary is restored once it has been re-dialled
+	assert.Eventually(t, func() bool { return client.Members()[0].Healthy }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "primary", whoami(t, client))
+}
+
+func TestFailoverClient_RetryInFlight(t *testing.T) {
+	primary, backup := newPoolServer(t, "primary"), newPoolServer(t, "backup")
+	close(backup.release)
+
+	client := newFailoverClient(t, []*poolServer{primary, backup}, jsonrpc.IdempotentMethods("block"))
+
+	req, _ := jsonrpc.NewRequest("block", nil, jsonrpc.RequestNumericId(1))
+	future := client.SendAsync(*req)
+
+	assert.Equal(t, int64(1), client.Members()[0].InFlight)
+	primary.drop()
+
+	resp, err := (<-future.Get()).Unwrap()
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`"backup"`), resp.Result)
+}
+
+func TestFailoverClient_ErrorCode(t *testing.T) {
+	primary, backup := newPoolServer(t, "primary"), newPoolServer(t, "backup")
+	primary.failing.Store(true)
+
+	client := newFailoverClient(t, []*poolServer{primary, backup}, jsonrpc.IdempotentMethods("flaky"),
+		jsonrpc.PoolHealthInterval(20*time.Millisecond),
+	)
+
+	// the primary fails, so the request is retried with the backup
+	name, err := jsonrpc.Call[any, string](context.Background(), client, "flaky", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "backup", name)
+	assert.False(t, client.Members()[0].Healthy)
+
+	// the primary remains ejected whilst it fails the health check
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, client.Members()[0].Healthy)
+	assert.Equal(t, "backup", whoami(t, client))
+
+	primary.failing.Store(false)
+	assert.Eventually(t, func() bool { return client.Members()[0].Healthy }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "primary", whoami(t, client))
+}
+
+func TestFailoverClient_NotIdempotent(t *testing.T) {
+	primary, backup := newPoolServer(t, "primary"), newPoolServer(t, "backup")
+	primary.failing.Store(true)
+
+	client := newFailoverClient(t, []*poolServer{primary, backup}, nil, jsonrpc.PoolHealthInterval(time.Hour))
+
+	_, err := jsonrpc.Call[any, string](context.Background(), client, "flaky", nil)
+	var rpcErr *jsonrpc.Error
+	assert.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, jsonrpc.ErrInternal.Code, rpcErr.Code)
+
+	// the error still moves subsequent requests on to the backup
+	assert.Equal(t, "backup", whoami(t, client))
+}
+
+func TestFailoverDialer(t *testing.T) {
+	backup := newPoolServer(t, "backup")
+
+	dialer := jsonrpc.FailoverDialer{Dialers: []jsonrpc.Dialer{
+		jsonrpc.WebSocketDialer{Url: "ws://127.0.0.1:1"},
+		backup.dialer(),
+	}}
+
+	client := jsonrpc.NewClient(dialer)
+	assert.Nil(t, client.Connect())
+	defer client.Close()
+
+	assert.Equal(t, "backup", whoami(t, client))
+
+	_, err := jsonrpc.FailoverDialer{}.Dial()
+	assert.NotNil(t, err)
+}
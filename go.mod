@@ -3,6 +3,7 @@ module github.com/41north/jsonrpc.go
 go 1.19
 
 require (
+	github.com/41north/async.go v0.0.0-20220930091129-528891be0173
 	github.com/gorilla/websocket v1.5.0
 	github.com/juju/errors v1.0.0
 	github.com/matoous/go-nanoid v1.5.0
@@ -11,7 +12,6 @@ require (
 )
 
 require (
-	github.com/41north/async.go v0.0.0-20220930091129-528891be0173 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.0.0-20220825204002-c680a09ffe64 // indirect
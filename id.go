@@ -0,0 +1,214 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// IdStrategy produces ids for requests which do not already have one.
+type IdStrategy interface {
+	NextId() (json.RawMessage, error)
+}
+
+type stringIds struct {
+	gen IdGenerator
+}
+
+// StringIds adapts an IdGenerator into an IdStrategy which produces string ids.
+func StringIds(gen IdGenerator) IdStrategy {
+	return stringIds{gen: gen}
+}
+
+func (s stringIds) NextId() (json.RawMessage, error) {
+	return json.Marshal(s.gen())
+}
+
+// NanoIds produces random string ids of the given size.
+func NanoIds(size int) IdStrategy {
+	return StringIds(func() string { return gonanoid.MustID(size) })
+}
+
+type sequentialIds struct {
+	next atomic.Int64
+}
+
+// SequentialIds produces monotonically increasing integer ids, starting at one.
+func SequentialIds() IdStrategy {
+	return &sequentialIds{}
+}
+
+func (s *sequentialIds) NextId() (json.RawMessage, error) {
+	return strconv.AppendInt(nil, s.next.Add(1), 10), nil
+}
+
+// UUIDv4Ids produces random version 4 UUID string ids.
+func UUIDv4Ids() IdStrategy {
+	return StringIds(func() string {
+		var uuid [16]byte
+		_, _ = rand.Read(uuid[:])
+		return formatUUID(uuid, 4)
+	})
+}
+
+// UUIDv7Ids produces time-ordered version 7 UUID string ids.
+func UUIDv7Ids() IdStrategy {
+	return StringIds(func() string {
+		var uuid [16]byte
+		_, _ = rand.Read(uuid[6:])
+
+		// the first 48 bits are the unix timestamp in milliseconds
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+		copy(uuid[:6], ts[2:])
+
+		return formatUUID(uuid, 7)
+	})
+}
+
+func formatUUID(uuid [16]byte, version byte) string {
+	uuid[6] = (uuid[6] & 0x0f) | version<<4
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf[:])
+}
+
+type prefixedIds struct {
+	prefix   string
+	strategy IdStrategy
+}
+
+// PrefixedIds produces string ids made up of prefix followed by an id from strategy.
+func PrefixedIds(prefix string, strategy IdStrategy) IdStrategy {
+	return prefixedIds{prefix: prefix, strategy: strategy}
+}
+
+func (p prefixedIds) NextId() (json.RawMessage, error) {
+	id, err := p.strategy.NextId()
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	if err := json.Unmarshal(id, &value); err != nil {
+		return nil, err
+	}
+
+	if str, ok := value.(string); ok {
+		return json.Marshal(p.prefix + str)
+	}
+	return json.Marshal(p.prefix + string(id))
+}
+
+// canonicalId returns a key for id such that ids which are equal in value, for example 1 and 1.0 or
+// strings with differing escape sequences, produce the same key. Ids are usually plain numbers or strings
+// without escapes, which are keyed without being decoded, otherwise codec decodes the string.
+func canonicalId(codec Codec, id json.RawMessage) string {
+	id = bytes.TrimSpace(id)
+	if len(id) == 0 {
+		return ""
+	}
+
+	switch first := id[0]; {
+	case first == '"':
+		if str, ok := plainString(id); ok {
+			return "s:" + str
+		}
+		var str string
+		if err := codec.Unmarshal(id, &str); err != nil {
+			return string(id)
+		}
+		return "s:" + str
+
+	case first == '-' || (first >= '0' && first <= '9'):
+		return "n:" + canonicalNumber(string(id))
+
+	case string(id) == "null":
+		return "null"
+
+	default:
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, id); err != nil {
+			return string(id)
+		}
+		return "r:" + buf.String()
+	}
+}
+
+// plainString returns the contents of a json string literal which contains no escape sequences.
+func plainString(raw []byte) (string, bool) {
+	if len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' || bytes.IndexByte(raw, '\\') >= 0 {
+		return "", false
+	}
+	return string(raw[1 : len(raw)-1]), true
+}
+
+// maxNumericIdLength bounds the numeric ids which are normalised, along with the magnitude of any exponent,
+// so that an id such as 1e1000000 is not expanded on the read loop. Larger ids are compared as received.
+const maxNumericIdLength = 64
+
+// canonicalNumber normalises a valid json number to plain decimal form without leading or trailing zeros.
+func canonicalNumber(s string) string {
+	if len(s) > maxNumericIdLength {
+		return s
+	}
+
+	n := strings.TrimPrefix(s, "-")
+	negative := len(n) < len(s)
+
+	exp := 0
+	if i := strings.IndexAny(n, "eE"); i >= 0 {
+		e, err := strconv.Atoi(n[i+1:])
+		if err != nil || e > maxNumericIdLength || e < -maxNumericIdLength {
+			return s
+		}
+		exp = e
+		n = n[:i]
+	}
+
+	whole, fraction, _ := strings.Cut(n, ".")
+	digits := whole + fraction
+
+	// move the decimal point by the exponent, padding with zeros where it falls outside the digits
+	point := len(whole) + exp
+	if point < 0 {
+		digits = strings.Repeat("0", -point) + digits
+		point = 0
+	}
+	if point > len(digits) {
+		digits += strings.Repeat("0", point-len(digits))
+	}
+
+	whole = strings.TrimLeft(digits[:point], "0")
+	fraction = strings.TrimRight(digits[point:], "0")
+
+	if whole == "" {
+		whole = "0"
+	}
+	result := whole
+	if fraction != "" {
+		result += "." + fraction
+	}
+	if negative && result != "0" {
+		result = "-" + result
+	}
+	return result
+}
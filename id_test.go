@@ -0,0 +1,116 @@
+package jsonrpc_test
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func nextId(t *testing.T, strategy jsonrpc.IdStrategy) string {
+	id, err := strategy.NextId()
+	assert.Nil(t, err)
+	return string(id)
+}
+
+func TestSequentialIds(t *testing.T) {
+	strategy := jsonrpc.SequentialIds()
+	assert.Equal(t, "1", nextId(t, strategy))
+	assert.Equal(t, "2", nextId(t, strategy))
+	assert.Equal(t, "3", nextId(t, strategy))
+}
+
+func TestNanoIds(t *testing.T) {
+	assert.Regexp(t, regexp.MustCompile(`^"[A-Za-z0-9_-]{12}"$`), nextId(t, jsonrpc.NanoIds(12)))
+}
+
+func TestUUIDIds(t *testing.T) {
+	v4 := regexp.MustCompile(`^"[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}"$`)
+	v7 := regexp.MustCompile(`^"[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}"$`)
+
+	assert.Regexp(t, v4, nextId(t, jsonrpc.UUIDv4Ids()))
+
+	strategy := jsonrpc.UUIDv7Ids()
+	first := nextId(t, strategy)
+	assert.Regexp(t, v7, first)
+
+	// version 7 ids are ordered by time
+	assert.LessOrEqual(t, first[:9], nextId(t, strategy)[:9])
+}
+
+func TestPrefixedIds(t *testing.T) {
+	strategy := jsonrpc.PrefixedIds("indexer-", jsonrpc.SequentialIds())
+	assert.Equal(t, `"indexer-1"`, nextId(t, strategy))
+
+	strategy = jsonrpc.PrefixedIds("gen-", jsonrpc.StringIds(func() string { return "abc" }))
+	assert.Equal(t, `"gen-abc"`, nextId(t, strategy))
+}
+
+func TestRequest_EnsureIdWith(t *testing.T) {
+	req := newRequest("ping", nil)
+	err := req.EnsureIdWith(jsonrpc.SequentialIds())
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage("1"), req.Id)
+
+	// existing ids are left untouched
+	err = req.EnsureIdWith(jsonrpc.PrefixedIds("x", jsonrpc.SequentialIds()))
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage("1"), req.Id)
+}
+
+func TestClient_CanonicalIdMatching(t *testing.T) {
+	// the server echoes ids in an equivalent but different representation
+	rewrites := map[string]string{
+		`1`:     `1.0`,
+		`2`:     `2e0`,
+		`3`:     `30e-1`,
+		`4`:     `0.04E+2`,
+		`"abc"`: `"abc"`,
+	}
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		for {
+			var req jsonrpc.Request
+			if err := c.ReadJSON(&req); err != nil {
+				return
+			}
+			id := json.RawMessage(rewrites[string(req.Id)])
+
+			// an id which would be costly to normalise is ignored
+			_ = c.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1e1000000,"result":null}`))
+			_ = c.WriteJSON(newResponse("pong", jsonrpc.ResponseId(id)))
+		}
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientIdStrategy(jsonrpc.SequentialIds()))
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	var resp jsonrpc.Response
+
+	// sequential ids are assigned
+	err = client.Send(*newRequest("ping", nil), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`1.0`), resp.Id)
+
+	err = client.Send(*newRequest("ping", nil), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`2e0`), resp.Id)
+
+	err = client.Send(*newRequest("ping", nil), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`30e-1`), resp.Id)
+
+	err = client.Send(*newRequest("ping", nil), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`0.04E+2`), resp.Id)
+
+	err = client.Send(*newRequest("ping", nil, jsonrpc.RequestStringId("abc")), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`"abc"`), resp.Id)
+}
@@ -0,0 +1,147 @@
+package jsonrpc
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Logger is a structured logger. Fields are provided as alternating keys and values, in the same manner
+// as log/slog.
+type Logger interface {
+	Debug(msg string, keyvals ...any)
+	Info(msg string, keyvals ...any)
+	Warn(msg string, keyvals ...any)
+	Error(msg string, keyvals ...any)
+
+	// With returns a Logger which includes the given fields on every line.
+	With(keyvals ...any) Logger
+}
+
+func defaultLogger() Logger {
+	return NewLogrusLogger(log.NewEntry(log.StandardLogger()))
+}
+
+type loggerKey struct{}
+
+// withLogger attaches the logger of a client to the context it dials with, so that dialers without a logger
+// of their own can use it.
+func withLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// contextLogger returns the logger attached by withLogger, or the default logger when there is none.
+func contextLogger(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return logger
+	}
+	return defaultLogger()
+}
+
+type logrusLogger struct {
+	entry *log.Entry
+}
+
+// NewLogrusLogger adapts a logrus entry into a Logger.
+func NewLogrusLogger(entry *log.Entry) Logger {
+	return logrusLogger{entry: entry}
+}
+
+func (l logrusLogger) Debug(msg string, keyvals ...any) {
+	l.entry.WithFields(toFields(keyvals)).Debug(msg)
+}
+
+func (l logrusLogger) Info(msg string, keyvals ...any) {
+	l.entry.WithFields(toFields(keyvals)).Info(msg)
+}
+
+func (l logrusLogger) Warn(msg string, keyvals ...any) {
+	l.entry.WithFields(toFields(keyvals)).Warn(msg)
+}
+
+func (l logrusLogger) Error(msg string, keyvals ...any) {
+	l.entry.WithFields(toFields(keyvals)).Error(msg)
+}
+
+func (l logrusLogger) With(keyvals ...any) Logger {
+	return logrusLogger{entry: l.entry.WithFields(toFields(keyvals))}
+}
+
+func toFields(keyvals []any) log.Fields {
+	fields := make(log.Fields, len(keyvals)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, ok := keyvals[i].(string)
+		if !ok {
+			key = "!BADKEY"
+		}
+		if i+1 < len(keyvals) {
+			fields[key] = keyvals[i+1]
+		} else {
+			fields[key] = nil
+		}
+	}
+	return fields
+}
+
+type nopLogger struct{}
+
+// NopLogger returns a Logger which discards everything.
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+func (n nopLogger) With(...any) Logger {
+	return n
+}
+
+type levelLogger struct {
+	logger Logger
+	level  Level
+}
+
+// NewLevelLogger returns a Logger which discards anything below level before passing it on to logger.
+func NewLevelLogger(logger Logger, level Level) Logger {
+	return levelLogger{logger: logger, level: level}
+}
+
+func (l levelLogger) Debug(msg string, keyvals ...any) {
+	if l.level <= LevelDebug {
+		l.logger.Debug(msg, keyvals...)
+	}
+}
+
+func (l levelLogger) Info(msg string, keyvals ...any) {
+	if l.level <= LevelInfo {
+		l.logger.Info(msg, keyvals...)
+	}
+}
+
+func (l levelLogger) Warn(msg string, keyvals ...any) {
+	if l.level <= LevelWarn {
+		l.logger.Warn(msg, keyvals...)
+	}
+}
+
+func (l levelLogger) Error(msg string, keyvals ...any) {
+	if l.level <= LevelError {
+		l.logger.Error(msg, keyvals...)
+	}
+}
+
+func (l levelLogger) With(keyvals ...any) Logger {
+	return levelLogger{logger: l.logger.With(keyvals...), level: l.level}
+}
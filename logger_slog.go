@@ -0,0 +1,36 @@
+//go:build go1.21
+
+package jsonrpc
+
+import (
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger adapts a log/slog logger into a Logger.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+func (l slogLogger) Debug(msg string, keyvals ...any) {
+	l.logger.Debug(msg, keyvals...)
+}
+
+func (l slogLogger) Info(msg string, keyvals ...any) {
+	l.logger.Info(msg, keyvals...)
+}
+
+func (l slogLogger) Warn(msg string, keyvals ...any) {
+	l.logger.Warn(msg, keyvals...)
+}
+
+func (l slogLogger) Error(msg string, keyvals ...any) {
+	l.logger.Error(msg, keyvals...)
+}
+
+func (l slogLogger) With(keyvals ...any) Logger {
+	return slogLogger{logger: l.logger.With(keyvals...)}
+}
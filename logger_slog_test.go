@@ -0,0 +1,42 @@
+//go:build go1.21
+
+package jsonrpc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+
+	jsonrpc.NewSlogLogger(slog.New(handler)).
+		With("connectionId", "abc").
+		Debug("something happened", "id", 1, "method", "ping")
+
+	var fields map[string]any
+	err := json.Unmarshal(buf.Bytes(), &fields)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{
+		"level":        "DEBUG",
+		"msg":          "something happened",
+		"connectionId": "abc",
+		"id":           float64(1),
+		"method":       "ping",
+	}, fields)
+}
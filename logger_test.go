@@ -0,0 +1,187 @@
+package jsonrpc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type logLine struct {
+	level   jsonrpc.Level
+	msg     string
+	keyvals []any
+}
+
+// recordingLogger captures log lines for inspection.
+type recordingLogger struct {
+	lock   *sync.Mutex
+	lines  *[]logLine
+	fields []any
+}
+
+func newRecordingLogger() recordingLogger {
+	return recordingLogger{lock: &sync.Mutex{}, lines: &[]logLine{}}
+}
+
+func (r recordingLogger) record(level jsonrpc.Level, msg string, keyvals []any) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	*r.lines = append(*r.lines, logLine{level, msg, append(append([]any{}, r.fields...), keyvals...)})
+}
+
+func (r recordingLogger) Lines() []logLine {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]logLine{}, *r.lines...)
+}
+
+func (r recordingLogger) Debug(msg string, keyvals ...any) {
+	r.record(jsonrpc.LevelDebug, msg, keyvals)
+}
+
+func (r recordingLogger) Info(msg string, keyvals ...any) {
+	r.record(jsonrpc.LevelInfo, msg, keyvals)
+}
+
+func (r recordingLogger) Warn(msg string, keyvals ...any) {
+	r.record(jsonrpc.LevelWarn, msg, keyvals)
+}
+
+func (r recordingLogger) Error(msg string, keyvals ...any) {
+	r.record(jsonrpc.LevelError, msg, keyvals)
+}
+
+func (r recordingLogger) With(keyvals ...any) jsonrpc.Logger {
+	return recordingLogger{lock: r.lock, lines: r.lines, fields: append(append([]any{}, r.fields...), keyvals...)}
+}
+
+func TestLogrusLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&log.JSONFormatter{DisableTimestamp: true})
+
+	jsonrpc.NewLogrusLogger(log.NewEntry(logger)).
+		With("connectionId", "abc").
+		Warn("something happened", "id", 1, "method", "ping")
+
+	var fields map[string]any
+	err := json.Unmarshal(buf.Bytes(), &fields)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{
+		"level":        "warning",
+		"msg":          "something happened",
+		"connectionId": "abc",
+		"id":           float64(1),
+		"method":       "ping",
+	}, fields)
+}
+
+func TestLevelLogger(t *testing.T) {
+	recorder := newRecordingLogger()
+	logger := jsonrpc.NewLevelLogger(recorder, jsonrpc.LevelWarn).With("foo", "bar")
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	assert.Equal(t, []logLine{
+		{jsonrpc.LevelWarn, "warn", []any{"foo", "bar"}},
+		{jsonrpc.LevelError, "error", []any{"foo", "bar"}},
+	}, recorder.Lines())
+}
+
+func TestClient_Logger(t *testing.T) {
+	srv := newWsServer(true)
+	defer srv.close()
+
+	recorder := newRecordingLogger()
+
+	dialer := jsonrpc.WebSocketDialer{Url: srv.url("/ws"), Logger: jsonrpc.NopLogger()}
+	client := jsonrpc.NewClient(dialer, jsonrpc.ClientLogger(recorder))
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	bytes, err := json.Marshal(newResponse("pong", jsonrpc.ResponseNumericId(42)))
+	assert.Nil(t, err)
+	srv.testMessages <- testMessage{msgType: websocket.TextMessage, data: bytes}
+
+	assert.Eventually(t, func() bool {
+		for _, line := range recorder.Lines() {
+			if line.msg == "response received with unrecognised id" {
+				assert.Equal(t, jsonrpc.LevelWarn, line.level)
+				assert.Equal(t, "connectionId", line.keyvals[0])
+				assert.Len(t, line.keyvals[1], 12)
+				assert.Equal(t, []any{"id", "42"}, line.keyvals[2:])
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClient_DialerFallsBackToClientLogger(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye")
+		_ = c.WriteMessage(websocket.CloseMessage, msg)
+	})
+	defer srv.Close()
+
+	recorder := newRecordingLogger()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientLogger(recorder))
+	assert.Nil(t, client.Connect())
+	defer client.Close()
+
+	// the connection logs the close frame to the client's logger rather than the logrus standard logger
+	assert.Eventually(t, func() bool {
+		for _, line := range recorder.Lines() {
+			if line.msg == "connection closed" {
+				assert.Equal(t, "remoteAddr", line.keyvals[0])
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNewWebSocketConnectionWithLogger(t *testing.T) {
+	recorder := newRecordingLogger()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := jsonrpc.NewWebSocketConnectionWithLogger(c, recorder)
+		defer conn.Close()
+		_, _ = conn.Read()
+	}))
+	defer srv.Close()
+
+	c, _, err := websocket.DefaultDialer.Dial(wsUrl(srv), nil)
+	assert.Nil(t, err)
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	assert.Nil(t, c.WriteMessage(websocket.CloseMessage, msg))
+	defer c.Close()
+
+	assert.Eventually(t, func() bool {
+		for _, line := range recorder.Lines() {
+			if line.msg == "connection closed" {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,267 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/juju/errors"
+)
+
+// MessageKind is the structural kind of a JSON-RPC message.
+type MessageKind int
+
+const (
+	MessageInvalid MessageKind = iota
+	MessageRequest
+	MessageNotification
+	MessageResponse
+	MessageErrorResponse
+	MessageBatch
+)
+
+var messageKindNames = [...]string{"invalid", "request", "notification", "response", "error response", "batch"}
+
+func (k MessageKind) String() string {
+	if k < 0 || int(k) >= len(messageKindNames) {
+		return "unknown"
+	}
+	return messageKindNames[k]
+}
+
+// InvalidMessageHandler is called with any message which is not a valid JSON-RPC message, along with
+// ErrParse or ErrInvalidRequest.
+type InvalidMessageHandler = func(data []byte, err error)
+
+// ClientInvalidMessageHandler sets the handler for malformed messages, which are otherwise logged.
+func ClientInvalidMessageHandler(handler InvalidMessageHandler) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.InvalidMessageHandler = handler
+	}
+}
+
+// rawView is a json.RawMessage which references the input rather than copying it, so it is only valid for
+// as long as the input.
+type rawView []byte
+
+func (r *rawView) UnmarshalJSON(data []byte) error {
+	*r = data
+	return nil
+}
+
+// messageProbe captures the members of a message object which determine its kind. A member which is
+// present but null is captured as `null`, allowing it to be distinguished from an absent member.
+type messageProbe struct {
+	Version rawView `json:"jsonrpc"`
+	Method  rawView `json:"method"`
+	Id      rawView `json:"id"`
+	Result  rawView `json:"result"`
+	Error   rawView `json:"error"`
+}
+
+func (p *messageProbe) isResponse() bool {
+	return p.Result != nil || p.Error != nil
+}
+
+// ClassifyMessage determines the kind of a message from its structure rather than its content. For an
+// invalid message the error is ErrParse if data is not JSON, otherwise ErrInvalidRequest.
+func ClassifyMessage(data []byte) (MessageKind, error) {
+	kind, _, err := classifyMessage(JSONCodec(), data)
+	return kind, err
+}
+
+// classifyMessage returns the kind of message along with its probe, which is nil for a batch or any message
+// that is not a JSON object.
+func classifyMessage(codec Codec, data []byte) (MessageKind, *messageProbe, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		switch {
+		case !json.Valid(data):
+			return MessageInvalid, nil, ErrParse
+		case data[0] == '[':
+			return MessageBatch, nil, nil
+		default:
+			return MessageInvalid, nil, ErrInvalidRequest
+		}
+	}
+
+	// unmarshalling validates the message, avoiding a separate pass over what may be a large result
+	var probe messageProbe
+	if err := codec.Unmarshal(data, &probe); err != nil {
+		return MessageInvalid, nil, ErrParse
+	}
+
+	hasId := probe.Id != nil
+	if hasId && !validId(probe.Id) {
+		// ignore the id when replying
+		probe.Id = nil
+		return MessageInvalid, &probe, ErrInvalidRequest
+	}
+
+	switch {
+	case probe.Method != nil:
+		if probe.Method[0] != '"' || probe.isResponse() {
+			return MessageInvalid, &probe, ErrInvalidRequest
+		}
+		if hasId {
+			return MessageRequest, &probe, nil
+		}
+		return MessageNotification, &probe, nil
+
+	case probe.Error != nil && !isNull(probe.Error):
+		if !hasId || probe.Error[0] != '{' {
+			return MessageInvalid, &probe, ErrInvalidRequest
+		}
+		return MessageErrorResponse, &probe, nil
+
+	case probe.Result != nil:
+		if !hasId {
+			return MessageInvalid, &probe, ErrInvalidRequest
+		}
+		return MessageResponse, &probe, nil
+
+	default:
+		return MessageInvalid, &probe, ErrInvalidRequest
+	}
+}
+
+// response creates a response from the probe, copying its members out of the message. The result is only
+// copied when withResult is set.
+func (p *messageProbe) response(codec Codec, withResult bool) (*Response, error) {
+	resp := &Response{Id: copyRaw(p.Id)}
+
+	if p.Version != nil {
+		if err := codec.Unmarshal(p.Version, &resp.Version); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Error != nil && !isNull(p.Error) {
+		var e Error
+		if err := codec.Unmarshal(p.Error, &e); err != nil {
+			return nil, err
+		}
+		resp.Error = &e
+	}
+
+	if withResult {
+		resp.Result = copyRaw(p.Result)
+	}
+
+	return resp, nil
+}
+
+func copyRaw(raw rawView) json.RawMessage {
+	if raw == nil {
+		return nil
+	}
+	return append(json.RawMessage(nil), raw...)
+}
+
+// validId reports whether id is a string, number or null, the only types the spec allows.
+func validId(id rawView) bool {
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	default:
+		return false
+	}
+}
+
+func isNull(raw rawView) bool {
+	return string(raw) == "null"
+}
+
+// onElement handles a single message, or an element of a batch, returning any response so that the
+// responses within a batch can be correlated together. Replies to the elements of a batch of requests are
+// collected by replies, which is nil for a single message.
+func (c *client) onElement(data []byte, replies *batchReply) *Response {
+	kind, probe, err := classifyMessage(c.opts.Codec, data)
+	switch kind {
+	case MessageRequest, MessageNotification:
+		var req Request
+		if err := c.opts.Codec.Unmarshal(data, &req); err != nil {
+			c.onInvalid(data, probe, withErrorData(ErrInvalidRequest, err), replies)
+			return nil
+		}
+		c.onRequest(req, replies)
+
+	case MessageResponse, MessageErrorResponse:
+		entry := c.decodingRequest(json.RawMessage(probe.Id))
+
+		resp, err := probe.response(c.opts.Codec, entry == nil)
+		if err != nil {
+			c.onInvalid(data, probe, withErrorData(ErrInvalidRequest, err), replies)
+			return nil
+		}
+
+		if entry != nil && resp.Error == nil {
+			if err := entry.decode(json.RawMessage(probe.Result)); err != nil {
+				entry.decodeErr = errors.Annotate(err, "failed to decode result")
+			}
+		}
+		return resp
+
+	case MessageBatch:
+		// batches cannot be nested
+		c.onInvalid(data, nil, ErrInvalidRequest, replies)
+
+	default:
+		c.onInvalid(data, probe, err, replies)
+	}
+	return nil
+}
+
+// onInvalid reports a malformed message. When a reply handler has been set the client is acting as a peer,
+// and replies with an error as the spec requires for anything other than a malformed response.
+func (c *client) onInvalid(data []byte, probe *messageProbe, err error, replies *batchReply) {
+	if handler := c.opts.InvalidMessageHandler; handler != nil {
+		// the message may be in a pooled buffer
+		handler(append([]byte(nil), data...), err)
+	} else {
+		c.logger().Warn("invalid message received", "error", err)
+	}
+
+	if !c.isPeer() || (probe != nil && probe.isResponse()) {
+		return
+	}
+
+	id := json.RawMessage("null")
+	if probe != nil && probe.Id != nil {
+		id = json.RawMessage(probe.Id)
+	}
+
+	resp, _ := NewResponseError(toError(err), ResponseId(id))
+	if replies != nil {
+		replies.add(resp)
+		return
+	}
+
+	bytes, marshalErr := c.opts.Codec.Marshal(resp)
+	if marshalErr != nil {
+		c.logger().Error("failed to marshal response", "error", marshalErr)
+		return
+	}
+
+	if writeErr := c.write(nil, nil, bytes); writeErr != nil {
+		c.logger().Warn("failed to send response", "error", writeErr)
+	}
+}
+
+// decodingRequest returns the in flight request for id if its result is to be decoded on the read loop.
+func (c *client) decodingRequest(id json.RawMessage) *inFlightRequest {
+	value, ok := c.inFlight.Load(canonicalId(c.opts.Codec, id))
+	if !ok {
+		return nil
+	}
+	entry := value.(*inFlightRequest)
+	if entry.decode == nil {
+		return nil
+	}
+	return entry
+}
+
+func (c *client) isPeer() bool {
+	c.middlewareLock.RLock()
+	defer c.middlewareLock.RUnlock()
+	return c.replyHandler != nil
+}
@@ -0,0 +1,120 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyMessage(t *testing.T) {
+	tests := []struct {
+		data string
+		kind jsonrpc.MessageKind
+		err  error
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}`, jsonrpc.MessageRequest, nil},
+		{`{"jsonrpc":"2.0","id":null,"method":"eth_call"}`, jsonrpc.MessageRequest, nil},
+		{`{"jsonrpc":"2.0","method":"eth_subscription","params":{}}`, jsonrpc.MessageNotification, nil},
+		{`{"jsonrpc":"2.0","id":"a","result":{"method":"transfer(address,uint256)"}}`, jsonrpc.MessageResponse, nil},
+		{`{"jsonrpc":"2.0","id":1,"result":null}`, jsonrpc.MessageResponse, nil},
+		{`{"jsonrpc":"2.0","id":1,"result":"0x1","error":null}`, jsonrpc.MessageResponse, nil},
+		{`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found"}}`, jsonrpc.MessageErrorResponse, nil},
+		{` [{"jsonrpc":"2.0","id":1,"result":1}]`, jsonrpc.MessageBatch, nil},
+		{`{"jsonrpc":"2.0","id":1,`, jsonrpc.MessageInvalid, jsonrpc.ErrParse},
+		{`"method"`, jsonrpc.MessageInvalid, jsonrpc.ErrInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1}`, jsonrpc.MessageInvalid, jsonrpc.ErrInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1,"method":1}`, jsonrpc.MessageInvalid, jsonrpc.ErrInvalidRequest},
+		{`{"jsonrpc":"2.0","id":{},"method":"eth_call"}`, jsonrpc.MessageInvalid, jsonrpc.ErrInvalidRequest},
+		{`{"jsonrpc":"2.0","result":1}`, jsonrpc.MessageInvalid, jsonrpc.ErrInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1,"error":"boom"}`, jsonrpc.MessageInvalid, jsonrpc.ErrInvalidRequest},
+	}
+
+	for _, test := range tests {
+		kind, err := jsonrpc.ClassifyMessage([]byte(test.data))
+		assert.Equal(t, test.kind, kind, test.data)
+		assert.Equal(t, test.err, err, test.data)
+	}
+}
+
+func TestClient_ResponseContainingMethod(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		result := map[string]string{"method": "transfer(address,uint256)"}
+		_ = c.WriteJSON(newResponse(result, jsonrpc.ResponseId(req.Id)))
+
+		// wait for the client to go away
+		_, _, _ = c.ReadMessage()
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("eth_call", nil), &resp)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"method":"transfer(address,uint256)"}`, string(resp.Result))
+}
+
+func TestClient_InvalidMessages(t *testing.T) {
+	replies := make(chan jsonrpc.Response, 3)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		_ = c.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0",`))
+		_ = c.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":7,"method":1}`))
+		// a malformed response is reported but not replied to
+		_ = c.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","result":1}`))
+		_ = c.WriteMessage(websocket.TextMessage, []byte(`[]`))
+
+		for i := 0; i < 3; i++ {
+			var resp jsonrpc.Response
+			if err := c.ReadJSON(&resp); err != nil {
+				return
+			}
+			replies <- resp
+		}
+	})
+	defer srv.Close()
+
+	invalid := make(chan error, 4)
+	client := jsonrpc.NewClient(
+		jsonrpc.WebSocketDialer{Url: wsUrl(srv)},
+		jsonrpc.ClientInvalidMessageHandler(func(data []byte, err error) {
+			invalid <- err
+		}),
+	)
+	client.SetReplyHandler(func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		return nil, nil
+	})
+
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	assert.Equal(t, jsonrpc.ErrParse, <-invalid)
+	assert.Equal(t, jsonrpc.ErrInvalidRequest, <-invalid)
+	assert.Equal(t, jsonrpc.ErrInvalidRequest, <-invalid)
+	assert.Equal(t, jsonrpc.ErrInvalidRequest, <-invalid)
+
+	resp := <-replies
+	assert.Equal(t, json.RawMessage("null"), resp.Id)
+	assert.Equal(t, jsonrpc.ErrParse.Code, resp.Error.Code)
+
+	resp = <-replies
+	assert.Equal(t, json.RawMessage("7"), resp.Id)
+	assert.Equal(t, jsonrpc.ErrInvalidRequest.Code, resp.Error.Code)
+
+	resp = <-replies
+	assert.Equal(t, json.RawMessage("null"), resp.Id)
+	assert.Equal(t, jsonrpc.ErrInvalidRequest.Code, resp.Error.Code)
+}
@@ -0,0 +1,103 @@
+package jsonrpc
+
+import (
+	"context"
+
+	"github.com/41north/async.go"
+)
+
+type (
+	// Invoker sends a request and returns the response. The response is nil when the request is a
+	// notification.
+	Invoker = func(ctx context.Context, req Request) (*Response, error)
+
+	// Middleware wraps an Invoker, allowing outbound requests and their responses to be inspected or
+	// modified, or for a response to be returned without calling next at all.
+	Middleware = func(next Invoker) Invoker
+
+	// HandlerMiddleware wraps the RequestHandler to which incoming notifications are delivered. When a reply
+	// handler has been set, the middleware wraps it and the sending of its response.
+	HandlerMiddleware = func(next RequestHandler) RequestHandler
+)
+
+func (c *client) Use(middleware ...Middleware) {
+	c.middlewareLock.Lock()
+	defer c.middlewareLock.Unlock()
+	c.middleware = append(c.middleware, middleware...)
+}
+
+func (c *client) UseHandler(middleware ...HandlerMiddleware) {
+	c.middlewareLock.Lock()
+	defer c.middlewareLock.Unlock()
+	c.handlerMiddleware = append(c.handlerMiddleware, middleware...)
+}
+
+// invoker returns the outbound middleware chain, or nil if no middleware has been added. The first
+// middleware added is the outermost.
+func (c *client) invoker() Invoker {
+	c.middlewareLock.RLock()
+	defer c.middlewareLock.RUnlock()
+
+	if len(c.middleware) == 0 {
+		return nil
+	}
+
+	invoker := c.invoke
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		invoker = c.middleware[i](invoker)
+	}
+	return invoker
+}
+
+// requestHandler returns the handler for an incoming request, wrapped in the handler middleware, and whether
+// it replies to the request. replies collects the reply when the request is part of a batch.
+func (c *client) requestHandler(replies *batchReply) (RequestHandler, bool) {
+	c.middlewareLock.RLock()
+	defer c.middlewareLock.RUnlock()
+
+	handler := c.reqHandler
+	if c.replyHandler != nil {
+		handler = c.reply(c.replyHandler, replies)
+	}
+	if handler == nil {
+		return nil, false
+	}
+
+	for i := len(c.handlerMiddleware) - 1; i >= 0; i-- {
+		handler = c.handlerMiddleware[i](handler)
+	}
+	return handler, c.replyHandler != nil
+}
+
+// invoke is the end of the middleware chain, sending the request over the connection.
+func (c *client) invoke(ctx context.Context, req Request) (*Response, error) {
+	if req.Id == nil {
+		return nil, c.notify(ctx, req)
+	}
+
+	decode, _ := ctx.Value(resultDecoderKey{}).(ResultDecoder)
+
+	// the context governs the lifetime of the request rather than a fixed timeout
+	select {
+	case <-ctx.Done():
+		c.cancel(req.Id, ctx.Err())
+		return nil, ctx.Err()
+	case result := <-c.sendAsync(req, 0, decode).Get():
+		return result.Unwrap()
+	}
+}
+
+// invokeAsync runs the middleware chain in the background, completing the returned future with the result.
+func (c *client) invokeAsync(invoker Invoker, req Request) ResponseFuture {
+	future := async.NewFuture[async.Result[*Response]]()
+	go func() {
+		ctx := context.Background()
+		if timeout := c.opts.RequestTimeout; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		future.Set(async.NewResult(invoker(ctx, req)))
+	}()
+	return future
+}
@@ -0,0 +1,116 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Middleware(t *testing.T) {
+	client := newServerClient(t, newTestServer())
+
+	var lock sync.Mutex
+	var calls []string
+
+	record := func(name string) jsonrpc.Middleware {
+		return func(next jsonrpc.Invoker) jsonrpc.Invoker {
+			return func(ctx context.Context, req jsonrpc.Request) (*jsonrpc.Response, error) {
+				lock.Lock()
+				calls = append(calls, name+":"+req.Method)
+				lock.Unlock()
+				return next(ctx, req)
+			}
+		}
+	}
+
+	// rewrites the params to include an auth token
+	auth := func(next jsonrpc.Invoker) jsonrpc.Invoker {
+		return func(ctx context.Context, req jsonrpc.Request) (*jsonrpc.Response, error) {
+			var params []string
+			if err := req.UnmarshalParams(&params); err != nil {
+				return nil, err
+			}
+			params = append(params, "token")
+			bytes, err := json.Marshal(params)
+			if err != nil {
+				return nil, err
+			}
+			req.Params = bytes
+			return next(ctx, req)
+		}
+	}
+
+	client.Use(record("first"), record("second"))
+	client.Use(auth)
+
+	var resp jsonrpc.Response
+	err := client.Send(*newRequest("echo", []string{"hello"}), &resp)
+	assert.Nil(t, err)
+
+	var result []string
+	err = resp.UnmarshalResult(&result)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hello", "token"}, result)
+
+	resp2, err := (<-client.SendAsync(*newRequest("echo", []string{"world"})).Get()).Unwrap()
+	assert.Nil(t, err)
+	err = resp2.UnmarshalResult(&result)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"world", "token"}, result)
+
+	assert.Equal(t, []string{"first:echo", "second:echo", "first:echo", "second:echo"}, calls)
+}
+
+func TestClient_MiddlewareShortCircuit(t *testing.T) {
+	client := newServerClient(t, newTestServer())
+
+	cached := newResponse([]string{"cached"})
+
+	client.Use(func(next jsonrpc.Invoker) jsonrpc.Invoker {
+		return func(ctx context.Context, req jsonrpc.Request) (*jsonrpc.Response, error) {
+			resp := *cached
+			resp.Id = req.Id
+			return &resp, nil
+		}
+	})
+
+	var resp jsonrpc.Response
+	err := client.Send(*newRequest("echo", []string{"hello"}, jsonrpc.RequestNumericId(7)), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage("7"), resp.Id)
+	assert.Equal(t, cached.Result, resp.Result)
+}
+
+func TestClient_HandlerMiddleware(t *testing.T) {
+	srv := newWsServer(true)
+	defer srv.close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: srv.url("/ws")})
+
+	requests := make(chan jsonrpc.Request, 1)
+	client.SetRequestHandler(func(req jsonrpc.Request) {
+		requests <- req
+	})
+
+	client.UseHandler(func(next jsonrpc.RequestHandler) jsonrpc.RequestHandler {
+		return func(req jsonrpc.Request) {
+			req.Method = "rewritten_" + req.Method
+			next(req)
+		}
+	})
+
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	bytes, err := json.Marshal(newRequest("ping", nil))
+	assert.Nil(t, err)
+	srv.testMessages <- testMessage{msgType: websocket.TextMessage, data: bytes}
+
+	assert.Equal(t, "rewritten_ping", (<-requests).Method)
+}
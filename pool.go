@@ -0,0 +1,782 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/41north/async.go"
+	"github.com/juju/errors"
+)
+
+var ErrNoHealthyConnections = errors.ConstError("no healthy connections in pool")
+
+// PoolBalancer determines how a PoolClient distributes requests across its connections.
+type PoolBalancer int
+
+const (
+	// RoundRobin sends each request to the next healthy connection in turn.
+	RoundRobin PoolBalancer = iota
+	// LeastInFlight sends each request to the healthy connection with the fewest requests awaiting a response.
+	LeastInFlight
+	// LatencyWeighted picks a healthy connection at random, weighted towards those with lower response times.
+	LatencyWeighted
+	// Failover sends every request to the first healthy connection, in the order of the dialers.
+	Failover
+)
+
+// HealthCheck determines whether a pooled connection is healthy, typically by calling an inexpensive method.
+type HealthCheck = func(ctx context.Context, client Client) error
+
+// PoolSize sets how many connections are made with each Dialer.
+func PoolSize(size int) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.Size = size
+	}
+}
+
+func PoolBalancing(balancer PoolBalancer) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.Balancer = balancer
+	}
+}
+
+// PoolHealthCheck sets a check which is run against each connection on every health interval. Connections
+// which fail are ejected until they pass again.
+func PoolHealthCheck(check HealthCheck) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.HealthCheck = check
+	}
+}
+
+// PoolHealthInterval sets how often connections are checked, and ejected connections are re-dialled.
+func PoolHealthInterval(interval time.Duration) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.HealthInterval = interval
+	}
+}
+
+// PoolMaxFailures sets how many consecutive requests may fail with ErrClosed or ErrDisconnected before a
+// connection is ejected.
+func PoolMaxFailures(failures int) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.MaxFailures = failures
+	}
+}
+
+// PoolClientOptions sets the options with which the client for each connection is created.
+func PoolClientOptions(options ...ClientOption) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.ClientOptions = append(opts.ClientOptions, options...)
+	}
+}
+
+type PoolOption = func(opts *PoolOptions)
+
+type PoolOptions struct {
+	Size           int
+	Balancer       PoolBalancer
+	HealthCheck    HealthCheck
+	HealthInterval time.Duration
+	MaxFailures    int
+	ClientOptions  []ClientOption
+
+	// FailoverCodes, Retries and Idempotent control failing over between connections, see NewFailoverClient.
+	FailoverCodes []int32
+	Retries       int
+	Idempotent    func(req Request) bool
+}
+
+func DefaultPoolOptions() PoolOptions {
+	return PoolOptions{
+		Size:           1,
+		Balancer:       RoundRobin,
+		HealthInterval: 10 * time.Second,
+		MaxFailures:    3,
+	}
+}
+
+// PoolMemberStats reports the state of a single connection within a PoolClient.
+type PoolMemberStats struct {
+	Healthy  bool
+	InFlight int64
+	// Latency is a moving average of response times.
+	Latency  time.Duration
+	Failures int32
+}
+
+// poolMember is a single connection within the pool, along with the measurements used for balancing.
+type poolMember struct {
+	dialer    Dialer
+	client    atomic.Value
+	healthy   atomic.Bool
+	connected atomic.Bool
+	inFlight  atomic.Int64
+	latency   atomic.Int64
+	failures  atomic.Int32
+}
+
+func (m *poolMember) get() Client {
+	c, _ := m.client.Load().(Client)
+	return c
+}
+
+func (m *poolMember) begin() time.Time {
+	m.inFlight.Add(1)
+	return time.Now()
+}
+
+// end records the outcome of a request, ejecting the member once too many have failed due to the connection.
+func (m *poolMember) end(start time.Time, err error, maxFailures int) {
+	m.inFlight.Add(-1)
+
+	if err != nil {
+		if errors.Is(err, ErrClosed) || errors.Is(err, ErrDisconnected) {
+			if m.failures.Add(1) >= int32(maxFailures) {
+				// the connection is re-dialled before the member is restored
+				m.connected.Store(false)
+				m.healthy.Store(false)
+			}
+		}
+		return
+	}
+
+	m.failures.Store(0)
+
+	// exponentially weighted moving average
+	sample := int64(time.Since(start))
+	if latency := m.latency.Load(); latency > 0 {
+		sample = (latency*4 + sample) / 5
+	}
+	m.latency.Store(sample)
+}
+
+// PoolClient is a Client which distributes requests across connections made with one or more Dialers. Each
+// connection has its own client, and requests relating to a subscription are kept on the connection which
+// created it.
+type PoolClient struct {
+	opts        PoolOptions
+	clientCodec Codec
+	log         Logger
+	members     []*poolMember
+	next        atomic.Uint64
+
+	// pins maps the id of subscriptions created with a `*_subscribe` request to their member
+	pins sync.Map
+
+	lock              sync.RWMutex
+	reqHandler        RequestHandler
+	replyHandler      Handler
+	middleware        []Middleware
+	handlerMiddleware []HandlerMiddleware
+	closeHandler      CloseHandler
+
+	closed  atomic.Bool
+	closing chan struct{}
+}
+
+func NewPoolClient(dialers []Dialer, options ...PoolOption) *PoolClient {
+	opts := DefaultPoolOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if opts.Size < 1 {
+		opts.Size = 1
+	}
+	if opts.MaxFailures < 1 {
+		opts.MaxFailures = 1
+	}
+
+	clientOpts := DefaultClientOptions()
+	for _, opt := range opts.ClientOptions {
+		opt(&clientOpts)
+	}
+	if clientOpts.Codec == nil {
+		clientOpts.Codec = JSONCodec()
+	}
+	if clientOpts.Logger == nil {
+		clientOpts.Logger = NopLogger()
+	}
+
+	p := &PoolClient{
+		opts:        opts,
+		clientCodec: clientOpts.Codec,
+		log:         clientOpts.Logger,
+		closing:     make(chan struct{}),
+	}
+
+	for _, dialer := range dialers {
+		for i := 0; i < opts.Size; i++ {
+			p.members = append(p.members, &poolMember{dialer: dialer})
+		}
+	}
+
+	return p
+}
+
+func (p *PoolClient) codec() Codec {
+	return p.clientCodec
+}
+
+// Connect connects every member of the pool, failing only if none could be connected. Members which could
+// not be connected are retried on each health interval.
+func (p *PoolClient) Connect() error {
+	var lastErr error
+	connected := 0
+
+	for _, m := range p.members {
+		if err := p.connect(m); err != nil {
+			p.log.Warn("failed to connect pool member", "error", err)
+			lastErr = err
+			continue
+		}
+		m.healthy.Store(true)
+		connected++
+	}
+
+	if connected == 0 {
+		if lastErr == nil {
+			return ErrNoHealthyConnections
+		}
+		return errors.Annotate(lastErr, "failed to connect any pool member")
+	}
+
+	if p.opts.HealthInterval > 0 {
+		go p.checkHealth()
+	}
+
+	return nil
+}
+
+// connect replaces the client of m with a newly connected one, configured with the pool's handlers and
+// middleware.
+func (p *PoolClient) connect(m *poolMember) error {
+	c := NewClient(m.dialer, p.opts.ClientOptions...)
+
+	p.lock.RLock()
+	c.Use(p.middleware...)
+	c.UseHandler(p.handlerMiddleware...)
+	if p.reqHandler != nil {
+		c.SetRequestHandler(p.reqHandler)
+	}
+	if p.replyHandler != nil {
+		c.SetReplyHandler(p.replyHandler)
+	}
+	p.lock.RUnlock()
+
+	c.SetCloseHandler(func(err error) {
+		// ignore clients which have already been replaced
+		if m.get() == c {
+			m.connected.Store(false)
+			m.healthy.Store(false)
+		}
+	})
+
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	m.client.Store(c)
+	m.failures.Store(0)
+	m.connected.Store(true)
+	return nil
+}
+
+func (p *PoolClient) checkHealth() {
+	ticker := time.NewTicker(p.opts.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.closing:
+			return
+		case <-ticker.C:
+			for _, m := range p.members {
+				p.checkMember(m)
+			}
+		}
+	}
+}
+
+// checkMember re-dials a member which has lost its connection, then runs the health check against it.
+// Members which were ejected but remain connected are restored once they pass the check.
+func (p *PoolClient) checkMember(m *poolMember) {
+	if !m.connected.Load() {
+		if c := m.get(); c != nil {
+			_ = c.Close()
+		}
+		if err := p.connect(m); err != nil {
+			p.log.Debug("failed to reconnect pool member", "error", err)
+			return
+		}
+	}
+
+	if check := p.opts.HealthCheck; check != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), p.opts.HealthInterval)
+		defer cancel()
+
+		if err := check(ctx, m.get()); err != nil {
+			if m.healthy.Swap(false) {
+				p.log.Warn("pool member failed health check, ejecting", "error", err)
+			}
+			return
+		}
+	}
+
+	if p.closed.Load() {
+		return
+	}
+	m.healthy.Store(true)
+}
+
+// pick chooses a healthy member according to the balancer.
+func (p *PoolClient) pick() (*poolMember, error) {
+	if p.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	var healthy []*poolMember
+	for _, m := range p.members {
+		if m.healthy.Load() {
+			healthy = append(healthy, m)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyConnections
+	}
+
+	switch p.opts.Balancer {
+	case LeastInFlight:
+		least := healthy[0]
+		for _, m := range healthy[1:] {
+			if m.inFlight.Load() < least.inFlight.Load() {
+				least = m
+			}
+		}
+		return least, nil
+
+	case LatencyWeighted:
+		return pickByLatency(healthy), nil
+
+	case Failover:
+		return healthy[0], nil
+
+	default:
+		return healthy[p.next.Add(1)%uint64(len(healthy))], nil
+	}
+}
+
+// pickByLatency picks a member at random with a probability inversely proportional to its latency. Members
+// without a measurement are treated as being as fast as the fastest measured member.
+func pickByLatency(members []*poolMember) *poolMember {
+	var fastest int64
+	for _, m := range members {
+		if latency := m.latency.Load(); latency > 0 && (fastest == 0 || latency < fastest) {
+			fastest = latency
+		}
+	}
+	if fastest == 0 {
+		return members[rand.Intn(len(members))]
+	}
+
+	weights := make([]float64, len(members))
+	var total float64
+	for i, m := range members {
+		latency := m.latency.Load()
+		if latency <= 0 {
+			latency = fastest
+		}
+		weights[i] = 1 / float64(latency)
+		total += weights[i]
+	}
+
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return members[i]
+		}
+		r -= weight
+	}
+	return members[len(members)-1]
+}
+
+// route returns the member for req, keeping an `*_unsubscribe` request on the member which holds the
+// subscription.
+func (p *PoolClient) route(req Request) (*poolMember, error) {
+	if strings.HasSuffix(req.Method, "_unsubscribe") {
+		var params []json.RawMessage
+		if err := p.clientCodec.Unmarshal(req.Params, &params); err == nil && len(params) > 0 {
+			if m, ok := p.pins.Load(subscriptionId(p.clientCodec, params[0])); ok {
+				return m.(*poolMember), nil
+			}
+		}
+	}
+	return p.pick()
+}
+
+// pin records the member on which a `*_subscribe` request created a subscription.
+func (p *PoolClient) pin(req Request, m *poolMember, resp *Response) {
+	if resp == nil || resp.Error != nil || resp.Result == nil {
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(req.Method, "_subscribe"):
+		p.pins.Store(subscriptionId(p.clientCodec, resp.Result), m)
+	case strings.HasSuffix(req.Method, "_unsubscribe"):
+		var params []json.RawMessage
+		if err := p.clientCodec.Unmarshal(req.Params, &params); err == nil && len(params) > 0 {
+			p.pins.Delete(subscriptionId(p.clientCodec, params[0]))
+		}
+	}
+}
+
+func (p *PoolClient) Send(req Request, resp *Response) error {
+	return p.SendContext(context.Background(), req, resp)
+}
+
+func (p *PoolClient) SendContext(ctx context.Context, req Request, resp *Response) error {
+	return p.do(ctx, func() (*poolMember, error) { return p.route(req) }, p.idempotent(req),
+		func(m *poolMember) (bool, error) {
+			*resp = Response{}
+			if err := m.get().SendContext(ctx, req, resp); err != nil {
+				return false, err
+			}
+			if p.failoverError(resp.Error) {
+				return true, nil
+			}
+			p.pin(req, m, resp)
+			return false, nil
+		})
+}
+
+func (p *PoolClient) SendAsync(req Request) ResponseFuture {
+	// the result is only passed on once it has been recorded, so that an unsubscribe which immediately
+	// follows a subscribe is routed correctly
+	future := async.NewFuture[async.Result[*Response]]()
+	var resp *Response
+	p.doAsync(func() (*poolMember, error) { return p.route(req) }, p.idempotent(req),
+		func(m *poolMember) func() (bool, error) {
+			sent := m.get().SendAsync(req)
+			return func() (bool, error) {
+				var err error
+				if resp, err = (<-sent.Get()).Unwrap(); err != nil {
+					return false, err
+				}
+				if p.failoverError(resp.Error) {
+					return true, nil
+				}
+				p.pin(req, m, resp)
+				return false, nil
+			}
+		},
+		func(err error) { future.Set(async.NewResult(resp, err)) })
+	return future
+}
+
+func (p *PoolClient) SendDecode(ctx context.Context, req Request, resp *Response, decode ResultDecoder) error {
+	// a subscription is pinned by the id in its result, so the result must be retained rather than decoded
+	// on the read loop
+	if strings.HasSuffix(req.Method, "_subscribe") || strings.HasSuffix(req.Method, "_unsubscribe") {
+		if err := p.SendContext(ctx, req, resp); err != nil {
+			return err
+		}
+		if resp.Error == nil && resp.Result != nil {
+			return decode(resp.Result)
+		}
+		return nil
+	}
+
+	return p.do(ctx, func() (*poolMember, error) { return p.route(req) }, p.idempotent(req),
+		func(m *poolMember) (bool, error) {
+			*resp = Response{}
+			if err := m.get().SendDecode(ctx, req, resp, decode); err != nil {
+				return false, err
+			}
+			return p.failoverError(resp.Error), nil
+		})
+}
+
+// SendBatch sends the whole batch over a single connection.
+func (p *PoolClient) SendBatch(ctx context.Context, reqs []Request) ([]Response, error) {
+	var resps []Response
+	err := p.do(ctx, p.pick, p.idempotent(reqs...), func(m *poolMember) (bool, error) {
+		var err error
+		if resps, err = m.get().SendBatch(ctx, reqs); err != nil {
+			return false, err
+		}
+		return p.failoverBatch(resps), nil
+	})
+	return resps, err
+}
+
+func (p *PoolClient) SendBatchAsync(reqs []Request) BatchFuture {
+	future := async.NewFuture[async.Result[[]Response]]()
+	var resps []Response
+	p.doAsync(p.pick, p.idempotent(reqs...),
+		func(m *poolMember) func() (bool, error) {
+			sent := m.get().SendBatchAsync(reqs)
+			return func() (bool, error) {
+				var err error
+				if resps, err = (<-sent.Get()).Unwrap(); err != nil {
+					return false, err
+				}
+				return p.failoverBatch(resps), nil
+			}
+		},
+		func(err error) { future.Set(async.NewResult(resps, err)) })
+	return future
+}
+
+// do sends using the member chosen by route. When the member's connection fails, or send reports that it
+// returned one of the failover codes, the request is retried with another member provided it is retryable.
+func (p *PoolClient) do(
+	ctx context.Context,
+	route func() (*poolMember, error),
+	retryable bool,
+	send func(m *poolMember) (bool, error),
+) error {
+	return p.retry(ctx, 0, route, retryable, send)
+}
+
+// doAsync is the asynchronous form of do. The first attempt is routed and sent before returning, so that
+// members are chosen in the order in which requests are sent, leaving only the wait for a response and any
+// retries to the background. send returns a function which waits for the response, and done is called with
+// the outcome.
+func (p *PoolClient) doAsync(
+	route func() (*poolMember, error),
+	retryable bool,
+	send func(m *poolMember) func() (bool, error),
+	done func(err error),
+) {
+	m, err := route()
+	if err != nil {
+		done(err)
+		return
+	}
+
+	start := m.begin()
+	wait := send(m)
+
+	go func() {
+		failover, err := wait()
+		if p.settle(m, start, failover, err) && retryable {
+			err = p.retry(context.Background(), 1, route, retryable, func(m *poolMember) (bool, error) {
+				return send(m)()
+			})
+		}
+		done(err)
+	}()
+}
+
+// retry makes attempts from the given attempt onwards until one succeeds or the retries are exhausted.
+func (p *PoolClient) retry(
+	ctx context.Context,
+	attempt int,
+	route func() (*poolMember, error),
+	retryable bool,
+	send func(m *poolMember) (bool, error),
+) error {
+	for ; ; attempt++ {
+		m, err := route()
+		if err != nil {
+			return err
+		}
+
+		start := m.begin()
+		failover, err := send(m)
+
+		failed := p.settle(m, start, failover, err)
+		if !failed || !retryable || attempt >= p.opts.Retries || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// settle records the outcome of an attempt with m, returning true if it failed in a way which another
+// member may not.
+func (p *PoolClient) settle(m *poolMember, start time.Time, failover bool, err error) bool {
+	m.end(start, err, p.opts.MaxFailures)
+
+	if failover && m.healthy.Swap(false) {
+		p.log.Warn("pool member returned a failover error, ejecting")
+	}
+
+	return failover || errors.Is(err, ErrClosed) || errors.Is(err, ErrDisconnected)
+}
+
+func (p *PoolClient) idempotent(reqs ...Request) bool {
+	if p.opts.Idempotent == nil || p.opts.Retries == 0 {
+		return false
+	}
+	for _, req := range reqs {
+		if !p.opts.Idempotent(req) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PoolClient) failoverError(e *Error) bool {
+	if e == nil {
+		return false
+	}
+	for _, code := range p.opts.FailoverCodes {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PoolClient) failoverBatch(resps []Response) bool {
+	for _, resp := range resps {
+		if p.failoverError(resp.Error) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe creates the subscription on a single connection, where it remains until it ends.
+func (p *PoolClient) Subscribe(ctx context.Context, method string, params any) (Subscription, error) {
+	m, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+	return m.get().Subscribe(ctx, method, params)
+}
+
+func (p *PoolClient) Notify(ctx context.Context, method string, params any) error {
+	m, err := p.pick()
+	if err != nil {
+		return err
+	}
+	return m.get().Notify(ctx, method, params)
+}
+
+func (p *PoolClient) Use(middleware ...Middleware) {
+	p.lock.Lock()
+	p.middleware = append(p.middleware, middleware...)
+	p.lock.Unlock()
+
+	p.forEachClient(func(c Client) { c.Use(middleware...) })
+}
+
+func (p *PoolClient) UseHandler(middleware ...HandlerMiddleware) {
+	p.lock.Lock()
+	p.handlerMiddleware = append(p.handlerMiddleware, middleware...)
+	p.lock.Unlock()
+
+	p.forEachClient(func(c Client) { c.UseHandler(middleware...) })
+}
+
+func (p *PoolClient) SetCloseHandler(handler CloseHandler) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.closeHandler = handler
+}
+
+func (p *PoolClient) SetRequestHandler(handler RequestHandler) {
+	p.lock.Lock()
+	p.reqHandler = handler
+	p.lock.Unlock()
+
+	p.forEachClient(func(c Client) { c.SetRequestHandler(handler) })
+}
+
+func (p *PoolClient) SetReplyHandler(handler Handler) {
+	p.lock.Lock()
+	p.replyHandler = handler
+	p.lock.Unlock()
+
+	p.forEachClient(func(c Client) { c.SetReplyHandler(handler) })
+}
+
+func (p *PoolClient) forEachClient(fn func(c Client)) {
+	for _, m := range p.members {
+		if c := m.get(); c != nil {
+			fn(c)
+		}
+	}
+}
+
+// Stats reports the combined load across every connection in the pool.
+func (p *PoolClient) Stats() ClientStats {
+	var stats ClientStats
+	p.forEachClient(func(c Client) {
+		s := c.Stats()
+		stats.InFlight += s.InFlight
+		stats.WriteQueueDepth += s.WriteQueueDepth
+		stats.Dispatcher.Queued += s.Dispatcher.Queued
+		stats.Dispatcher.Active += s.Dispatcher.Active
+		stats.Dispatcher.Handled += s.Dispatcher.Handled
+		stats.Dispatcher.Blocked += s.Dispatcher.Blocked
+	})
+	return stats
+}
+
+// Members reports the state of each connection in the pool, in the order of the dialers.
+func (p *PoolClient) Members() []PoolMemberStats {
+	stats := make([]PoolMemberStats, len(p.members))
+	for i, m := range p.members {
+		stats[i] = PoolMemberStats{
+			Healthy:  m.healthy.Load(),
+			InFlight: m.inFlight.Load(),
+			Latency:  time.Duration(m.latency.Load()),
+			Failures: m.failures.Load(),
+		}
+	}
+	return stats
+}
+
+func (p *PoolClient) Close() error {
+	if !p.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	close(p.closing)
+
+	p.forEachClient(func(c Client) { _ = c.Close() })
+	p.onClosed()
+	return nil
+}
+
+// Shutdown shuts down every connection in the pool concurrently, returning the first error encountered.
+func (p *PoolClient) Shutdown(ctx context.Context) error {
+	if !p.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	close(p.closing)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(p.members))
+	p.forEachClient(func(c Client) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Shutdown(ctx); err != nil && !errors.Is(err, ErrClosed) {
+				errs <- err
+			}
+		}()
+	})
+	wg.Wait()
+	close(errs)
+
+	p.onClosed()
+	return <-errs
+}
+
+func (p *PoolClient) onClosed() {
+	p.lock.RLock()
+	handler := p.closeHandler
+	p.lock.RUnlock()
+
+	if handler != nil {
+		handler(nil)
+	}
+}
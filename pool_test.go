@@ -0,0 +1,317 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
+	"github.com/stretchr/testify/assert"
+)
+
+// poolServer is a named endpoint whose connections can be dropped.
+type poolServer struct {
+	name    string
+	srv     *httptest.Server
+	release chan struct{}
+	delay   time.Duration
+	failing atomic.Bool
+
+	lock  sync.Mutex
+	conns []jsonrpc.Connection
+}
+
+func newPoolServer(t *testing.T, name string) *poolServer {
+	p := &poolServer{name: name, release: make(chan struct{})}
+
+	srv := jsonrpc.NewServer()
+	srv.Register("whoami", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		time.Sleep(p.delay)
+		return p.name, nil
+	})
+	srv.Register("flaky", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		if p.failing.Load() {
+			return nil, jsonrpc.ErrInternal
+		}
+		return p.name, nil
+	})
+	srv.Register("block", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		<-p.release
+		return p.name, nil
+	})
+	srv.Register("test_subscribe", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		return p.name, nil
+	})
+	srv.Register("test_unsubscribe", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		var params []string
+		if err := req.UnmarshalParams(&params); err != nil {
+			return nil, jsonrpc.ErrInvalidParams
+		}
+		return len(params) == 1 && params[0] == p.name, nil
+	})
+
+	p.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := jsonrpc.NewWebSocketConnection(c)
+		p.lock.Lock()
+		p.conns = append(p.conns, conn)
+		p.lock.Unlock()
+		_ = srv.Serve(r.Context(), conn)
+	}))
+	t.Cleanup(p.srv.Close)
+
+	return p
+}
+
+func (p *poolServer) dialer() jsonrpc.Dialer {
+	return jsonrpc.WebSocketDialer{Url: wsUrl(p.srv)}
+}
+
+// drop closes every connection made to the server so far.
+func (p *poolServer) drop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+func newPool(t *testing.T, servers []*poolServer, options ...jsonrpc.PoolOption) *jsonrpc.PoolClient {
+	var dialers []jsonrpc.Dialer
+	for _, srv := range servers {
+		dialers = append(dialers, srv.dialer())
+	}
+
+	pool := jsonrpc.NewPoolClient(dialers, options...)
+	if err := pool.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pool.Close() })
+	return pool
+}
+
+func whoami(t *testing.T, client jsonrpc.Client) string {
+	name, err := jsonrpc.Call[any, string](context.Background(), client, "whoami", nil)
+	assert.Nil(t, err)
+	return name
+}
+
+func TestPoolClient_RoundRobin(t *testing.T) {
+	pool := newPool(t, []*poolServer{newPoolServer(t, "a"), newPoolServer(t, "b")})
+
+	counts := map[string]int{}
+	for i := 0; i < 10; i++ {
+		counts[whoami(t, pool)]++
+	}
+	assert.Equal(t, map[string]int{"a": 5, "b": 5}, counts)
+}
+
+func TestPoolClient_Size(t *testing.T) {
+	pool := newPool(t, []*poolServer{newPoolServer(t, "a")}, jsonrpc.PoolSize(3))
+	assert.Len(t, pool.Members(), 3)
+	assert.Equal(t, "a", whoami(t, pool))
+}
+
+func TestPoolClient_LeastInFlight(t *testing.T) {
+	a, b := newPoolServer(t, "a"), newPoolServer(t, "b")
+	pool := newPool(t, []*poolServer{a, b}, jsonrpc.PoolBalancing(jsonrpc.LeastInFlight))
+
+	req, _ := jsonrpc.NewRequest("block", nil, jsonrpc.RequestNumericId(1))
+	blocked := pool.SendAsync(*req)
+	assert.Equal(t, int64(1), pool.Members()[0].InFlight)
+
+	// the first member is busy, so everything else goes to the second
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "b", whoami(t, pool))
+	}
+
+	close(a.release)
+	close(b.release)
+	resp, err := (<-blocked.Get()).Unwrap()
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`"a"`), resp.Result)
+}
+
+func TestPoolClient_SendAsyncOrder(t *testing.T) {
+	pool := newPool(t, []*poolServer{newPoolServer(t, "a"), newPoolServer(t, "b")})
+
+	// members are chosen as each request is sent, not when a background goroutine happens to run
+	var futures []jsonrpc.ResponseFuture
+	for i := 0; i < 6; i++ {
+		req, _ := jsonrpc.NewRequest("whoami", nil, jsonrpc.RequestNumericId(i))
+		futures = append(futures, pool.SendAsync(*req))
+	}
+
+	var names []string
+	for _, future := range futures {
+		resp, err := (<-future.Get()).Unwrap()
+		assert.Nil(t, err)
+		var name string
+		assert.Nil(t, json.Unmarshal(resp.Result, &name))
+		names = append(names, name)
+	}
+	for i := 1; i < len(names); i++ {
+		assert.NotEqual(t, names[i-1], names[i])
+	}
+}
+
+func TestPoolClient_LatencyWeighted(t *testing.T) {
+	slow, fast := newPoolServer(t, "slow"), newPoolServer(t, "fast")
+	slow.delay = 50 * time.Millisecond
+
+	pool := newPool(t, []*poolServer{slow, fast}, jsonrpc.PoolBalancing(jsonrpc.LatencyWeighted))
+
+	// wait for both members to have been measured
+	for i := 0; i < 100; i++ {
+		members := pool.Members()
+		if members[0].Latency > 0 && members[1].Latency > 0 {
+			break
+		}
+		whoami(t, pool)
+	}
+	assert.Greater(t, pool.Members()[0].Latency, pool.Members()[1].Latency)
+
+	counts := map[string]int{}
+	for i := 0; i < 20; i++ {
+		counts[whoami(t, pool)]++
+	}
+	assert.GreaterOrEqual(t, counts["fast"], 18)
+}
+
+func TestPoolClient_Ejection(t *testing.T) {
+	a, b := newPoolServer(t, "a"), newPoolServer(t, "b")
+	pool := newPool(t, []*poolServer{a, b}, jsonrpc.PoolHealthInterval(20*time.Millisecond))
+
+	a.drop()
+	assert.Eventually(t, func() bool { return !pool.Members()[0].Healthy }, time.Second, 5*time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "b", whoami(t, pool))
+	}
+
+	// the health check re-dials the dropped member
+	assert.Eventually(t, func() bool { return pool.Members()[0].Healthy }, time.Second, 5*time.Millisecond)
+
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		counts[whoami(t, pool)]++
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, counts)
+}
+
+func TestPoolClient_HealthCheck(t *testing.T) {
+	a, b := newPoolServer(t, "a"), newPoolServer(t, "b")
+
+	check := func(ctx context.Context, client jsonrpc.Client) error {
+		name, err := jsonrpc.Call[any, string](ctx, client, "whoami", nil)
+		if err == nil && name == "a" {
+			return jsonrpc.ErrInternal
+		}
+		return err
+	}
+
+	pool := newPool(t, []*poolServer{a, b},
+		jsonrpc.PoolHealthCheck(check),
+		jsonrpc.PoolHealthInterval(20*time.Millisecond),
+	)
+
+	assert.Eventually(t, func() bool { return !pool.Members()[0].Healthy }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "b", whoami(t, pool))
+}
+
+func TestPoolClient_NoHealthyConnections(t *testing.T) {
+	a := newPoolServer(t, "a")
+	pool := newPool(t, []*poolServer{a}, jsonrpc.PoolHealthInterval(time.Hour))
+
+	a.drop()
+	assert.Eventually(t, func() bool { return !pool.Members()[0].Healthy }, time.Second, 5*time.Millisecond)
+
+	_, err := jsonrpc.Call[any, string](context.Background(), pool, "whoami", nil)
+	assert.ErrorIs(t, err, jsonrpc.ErrNoHealthyConnections)
+}
+
+func TestPoolClient_ConnectFailure(t *testing.T) {
+	pool := jsonrpc.NewPoolClient([]jsonrpc.Dialer{jsonrpc.WebSocketDialer{Url: "ws://127.0.0.1:1"}})
+	assert.NotNil(t, pool.Connect())
+}
+
+func TestPoolClient_SubscriptionPinning(t *testing.T) {
+	pool := newPool(t, []*poolServer{newPoolServer(t, "a"), newPoolServer(t, "b")})
+
+	// round robin would otherwise send each unsubscribe to the other server
+	for i := 0; i < 4; i++ {
+		var resp jsonrpc.Response
+		req, _ := jsonrpc.NewRequest("test_subscribe", nil, jsonrpc.RequestNumericId(i))
+		assert.Nil(t, pool.Send(*req, &resp))
+
+		var id string
+		assert.Nil(t, json.Unmarshal(resp.Result, &id))
+
+		unsubscribed, err := jsonrpc.Call[[]string, bool](context.Background(), pool, "test_unsubscribe", []string{id})
+		assert.Nil(t, err)
+		assert.True(t, unsubscribed)
+	}
+}
+
+func TestPoolClient_SubscriptionPinningCall(t *testing.T) {
+	pool := newPool(t, []*poolServer{newPoolServer(t, "a"), newPoolServer(t, "b")})
+
+	// the typed helper decodes results as they are read, yet subscriptions must still be pinned
+	for i := 0; i < 4; i++ {
+		id, err := jsonrpc.Call[any, string](context.Background(), pool, "test_subscribe", nil)
+		assert.Nil(t, err)
+
+		unsubscribed, err := jsonrpc.Call[[]string, bool](context.Background(), pool, "test_unsubscribe", []string{id})
+		assert.Nil(t, err)
+		assert.True(t, unsubscribed)
+	}
+}
+
+func TestPoolClient_Close(t *testing.T) {
+	pool := newPool(t, []*poolServer{newPoolServer(t, "a")})
+
+	closed := make(chan error, 1)
+	pool.SetCloseHandler(func(err error) { closed <- err })
+
+	assert.Nil(t, pool.Close())
+	assert.Nil(t, <-closed)
+	assert.ErrorIs(t, pool.Close(), jsonrpc.ErrClosed)
+
+	_, err := jsonrpc.Call[any, string](context.Background(), pool, "whoami", nil)
+	assert.ErrorIs(t, err, jsonrpc.ErrClosed)
+}
+
+func TestPoolClient_SharedDispatcher(t *testing.T) {
+	a := newPoolServer(t, "a")
+
+	dispatcher := jsonrpc.PoolDispatcher(2, 0)
+	defer dispatcher.Close()
+
+	pool := newPool(t, []*poolServer{a},
+		jsonrpc.PoolHealthInterval(20*time.Millisecond),
+		jsonrpc.PoolClientOptions(jsonrpc.ClientDispatcher(dispatcher)),
+	)
+
+	// replacing the member's client must not close the shared dispatcher
+	a.drop()
+	assert.Eventually(t, func() bool { return !pool.Members()[0].Healthy }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return pool.Members()[0].Healthy }, time.Second, 5*time.Millisecond)
+
+	handled := make(chan struct{})
+	dispatcher.Dispatch(jsonrpc.Request{Method: "ping"}, func(jsonrpc.Request) { close(handled) })
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher was closed")
+	}
+}
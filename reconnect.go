@@ -0,0 +1,200 @@
+package jsonrpc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/41north/async.go"
+	"github.com/juju/errors"
+)
+
+var ErrDisconnected = errors.ConstError("connection has been lost")
+
+// InFlightPolicy determines what happens to in-flight requests when the connection is lost and the client
+// attempts to reconnect.
+type InFlightPolicy int
+
+const (
+	// FailInFlight completes all in-flight requests with ErrDisconnected.
+	FailInFlight InFlightPolicy = iota
+	// ReplayInFlight re-sends all in-flight requests once a new connection has been established.
+	ReplayInFlight
+)
+
+type (
+	DisconnectHandler = func(err error)
+	ReconnectHandler  = func(attempt int, err error)
+)
+
+// ReconnectPolicy controls how a client re-dials its Dialer after the connection has been lost.
+type ReconnectPolicy struct {
+	// MaxAttempts is the number of dial attempts made before the client is closed, zero means unlimited.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts.
+	MaxBackoff time.Duration
+	// Multiplier is applied to the delay after each failed attempt.
+	Multiplier float64
+	// Jitter randomises each delay by up to the given fraction in either direction.
+	Jitter float64
+	// DialTimeout bounds each attempt, zero means no timeout.
+	DialTimeout time.Duration
+	// InFlight determines whether in-flight requests are failed or replayed.
+	InFlight InFlightPolicy
+}
+
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		DialTimeout:    10 * time.Second,
+		InFlight:       FailInFlight,
+	}
+}
+
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	multiplier := math.Max(p.Multiplier, 1)
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 {
+		backoff = math.Min(backoff, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(backoff)
+}
+
+func (c *client) onDisconnect(conn Connection, err error) {
+	policy := c.opts.ReconnectPolicy
+	if policy == nil || c.closed.Load() {
+		c.close(err)
+		return
+	}
+
+	c.connLock.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.reconnecting.Store(true)
+	c.connLock.Unlock()
+
+	if err := conn.Close(); err != nil {
+		c.logger().Debug("failed to close connection", "error", err)
+	}
+
+	c.logger().Warn("connection lost", "error", err)
+
+	if c.opts.DisconnectHandler != nil {
+		c.opts.DisconnectHandler(err)
+	}
+
+	// subscriptions do not survive the connection they were created on
+	c.endSubscriptions(ErrDisconnected)
+
+	if policy.InFlight == FailInFlight {
+		c.failInFlight(ErrDisconnected)
+	}
+
+	c.reconnect(*policy, err)
+}
+
+func (c *client) reconnect(policy ReconnectPolicy, cause error) {
+	for attempt := 1; policy.MaxAttempts <= 0 || attempt <= policy.MaxAttempts; attempt++ {
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-c.closing:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		conn, err := c.dial(policy.DialTimeout)
+
+		if c.opts.ReconnectHandler != nil {
+			c.opts.ReconnectHandler(attempt, err)
+		}
+
+		if err != nil {
+			c.logger().Warn("reconnect failure", "error", err, "attempt", attempt)
+			cause = err
+			continue
+		}
+
+		if !c.restore(conn) {
+			// the client was closed whilst dialling
+			_ = conn.Close()
+		}
+		return
+	}
+
+	c.close(cause)
+}
+
+// dialContext returns the context with which connections are dialled, carrying the logger and codec of the
+// client for dialers which do not have their own.
+func (c *client) dialContext() context.Context {
+	return withCodec(withLogger(context.Background(), c.opts.Logger), c.opts.Codec)
+}
+
+func (c *client) dial(timeout time.Duration) (Connection, error) {
+	ctx := c.dialContext()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return c.dialer.DialContext(ctx)
+}
+
+// restore replaces the lost connection and replays any outstanding requests. The outstanding requests are
+// gathered whilst holding the connection lock, so that those sent concurrently are written by their sender
+// rather than replayed, but are written after releasing it as the new peer may not be reading.
+func (c *client) restore(conn Connection) bool {
+	c.connLock.Lock()
+
+	if c.closed.Load() {
+		c.connLock.Unlock()
+		return false
+	}
+
+	c.watchWrites(conn)
+	c.conn = conn
+	c.reconnecting.Store(false)
+	c.onConnected()
+
+	generation := c.replays.Add(1)
+
+	type outstanding struct {
+		key   any
+		entry *inFlightRequest
+	}
+	var replay []outstanding
+	c.inFlight.Range(func(key, value any) bool {
+		replay = append(replay, outstanding{key, value.(*inFlightRequest)})
+		return true
+	})
+
+	// start reading before replaying as some connections only accept writes whilst being read from
+	c.readers.Add(1)
+	go c.readMessages(conn)
+
+	c.connLock.Unlock()
+
+	for _, r := range replay {
+		// skip requests which have since completed, or been replayed by a later restore
+		if value, ok := c.inFlight.Load(r.key); !ok || value != r.entry || !r.entry.replaying(generation) {
+			continue
+		}
+		if err := conn.Write(r.entry.data); err != nil {
+			c.inFlight.Delete(r.key)
+			r.entry.complete(async.NewResultErr[*Response](err))
+		}
+	}
+
+	return true
+}
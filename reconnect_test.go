@@ -0,0 +1,186 @@
+package jsonrpc_test
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func testReconnectPolicy(inFlight jsonrpc.InFlightPolicy) jsonrpc.ReconnectPolicy {
+	policy := jsonrpc.DefaultReconnectPolicy()
+	policy.InitialBackoff = 10 * time.Millisecond
+	policy.MaxBackoff = 50 * time.Millisecond
+	policy.InFlight = inFlight
+	return policy
+}
+
+func TestClient_ReconnectReplayInFlight(t *testing.T) {
+	srv := newWsServer(false)
+	srv.closeOnNextMessage.Store(true)
+	defer srv.close()
+
+	disconnects := make(chan error, 1)
+	reconnects := make(chan error, 1)
+
+	dialer := jsonrpc.WebSocketDialer{Url: srv.url("/ws")}
+	client := jsonrpc.NewClient(
+		dialer,
+		jsonrpc.ClientReconnectPolicy(testReconnectPolicy(jsonrpc.ReplayInFlight)),
+		jsonrpc.ClientDisconnectHandler(func(err error) { disconnects <- err }),
+		jsonrpc.ClientReconnectHandler(func(attempt int, err error) { reconnects <- err }),
+	)
+
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	pong := newResponse("pong", jsonrpc.ResponseNumericId(1))
+	pongBytes, err := json.Marshal(pong)
+	assert.Nil(t, err)
+	srv.testMessages <- testMessage{msgType: websocket.TextMessage, data: pongBytes}
+
+	// the first connection is dropped upon receiving the request which is then replayed
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("ping", nil, jsonrpc.RequestNumericId(1)), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, *pong, resp)
+
+	assert.ErrorIs(t, <-disconnects, jsonrpc.ErrClosed)
+	assert.Nil(t, <-reconnects)
+}
+
+func TestClient_ReconnectFailInFlight(t *testing.T) {
+	srv := newWsServer(false)
+	srv.closeOnNextMessage.Store(true)
+	defer srv.close()
+
+	reconnects := make(chan error, 1)
+
+	dialer := jsonrpc.WebSocketDialer{Url: srv.url("/ws")}
+	client := jsonrpc.NewClient(
+		dialer,
+		jsonrpc.ClientReconnectPolicy(testReconnectPolicy(jsonrpc.FailInFlight)),
+		jsonrpc.ClientReconnectHandler(func(attempt int, err error) { reconnects <- err }),
+	)
+
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("ping", nil, jsonrpc.RequestNumericId(1)), &resp)
+	assert.Equal(t, jsonrpc.ErrDisconnected, err)
+
+	// wait for the connection to be re-established
+	assert.Nil(t, <-reconnects)
+
+	pong := newResponse("pong", jsonrpc.ResponseNumericId(2))
+	pongBytes, err := json.Marshal(pong)
+	assert.Nil(t, err)
+	srv.testMessages <- testMessage{msgType: websocket.TextMessage, data: pongBytes}
+
+	err = client.Send(*newRequest("ping", nil, jsonrpc.RequestNumericId(2)), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, *pong, resp)
+}
+
+func TestClient_ReconnectMaxAttempts(t *testing.T) {
+	srv := newWsServer(false)
+	srv.closeOnNextMessage.Store(true)
+
+	policy := testReconnectPolicy(jsonrpc.FailInFlight)
+	policy.MaxAttempts = 2
+
+	attempts := make(chan int, 2)
+	closeErrors := make(chan error, 1)
+
+	dialer := jsonrpc.WebSocketDialer{Url: srv.url("/ws")}
+	client := jsonrpc.NewClient(
+		dialer,
+		jsonrpc.ClientReconnectPolicy(policy),
+		jsonrpc.ClientReconnectHandler(func(attempt int, err error) {
+			assert.Error(t, err)
+			attempts <- attempt
+		}),
+	)
+	client.SetCloseHandler(func(err error) { closeErrors <- err })
+
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	// stop the server so that every reconnect attempt fails, then trigger the disconnect
+	srv.close()
+
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("ping", nil), &resp)
+	assert.Equal(t, jsonrpc.ErrDisconnected, err)
+
+	assert.Error(t, <-closeErrors)
+	assert.Equal(t, 1, <-attempts)
+	assert.Equal(t, 2, <-attempts)
+
+	err = client.Send(*newRequest("ping", nil), &resp)
+	assert.Equal(t, jsonrpc.ErrClosed, err)
+}
+
+func TestClient_CloseWhilstReplaying(t *testing.T) {
+	const requests = 16
+
+	var connections atomic.Int32
+	replaying := make(chan struct{})
+	done := make(chan struct{})
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		if connections.Add(1) == 1 {
+			// accept every request then drop the connection, leaving them to be replayed
+			for i := 0; i < requests; i++ {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+			return
+		}
+
+		// never read, so that the replay backs up
+		close(replaying)
+		<-done
+	})
+	defer srv.Close()
+	defer close(done)
+
+	client := jsonrpc.NewClient(
+		jsonrpc.WebSocketDialer{Url: wsUrl(srv)},
+		jsonrpc.ClientReconnectPolicy(testReconnectPolicy(jsonrpc.ReplayInFlight)),
+	)
+	assert.Nil(t, client.Connect())
+
+	req := newRequest("echo", []string{strings.Repeat("a", 1<<20)})
+	futures := make(chan jsonrpc.ResponseFuture, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			futures <- client.SendAsync(*req)
+		}()
+	}
+
+	<-replaying
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("close did not return")
+	}
+
+	for i := 0; i < requests; i++ {
+		_, err := (<-(<-futures).Get()).Unwrap()
+		assert.Error(t, err)
+	}
+}
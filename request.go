@@ -40,11 +40,13 @@ type RequestOption = func(opts *RequestOptions) error
 type RequestOptions struct {
 	Version string
 	Id      json.RawMessage
+	Codec   Codec
 }
 
 func DefaultRequestOptions() RequestOptions {
 	return RequestOptions{
 		Version: "2.0",
+		Codec:   JSONCodec(),
 	}
 }
 
@@ -60,7 +62,7 @@ func NewRequest(method string, params any, options ...RequestOption) (*Request,
 	var paramBytes json.RawMessage
 
 	if params != nil {
-		paramBytes, err = json.Marshal(params)
+		paramBytes, err = opts.Codec.Marshal(params)
 		if err != nil {
 			return nil, errors.New("failed to marshal params to json")
 		}
@@ -79,14 +81,19 @@ type Request struct {
 }
 
 func (r *Request) EnsureId(gen IdGenerator) error {
+	return r.EnsureIdWith(StringIds(gen))
+}
+
+// EnsureIdWith assigns an id produced by strategy if the request does not already have one.
+func (r *Request) EnsureIdWith(strategy IdStrategy) error {
 	if r.Id != nil {
 		return nil
 	}
-	bytes, err := json.Marshal(gen())
+	id, err := strategy.NextId()
 	if err != nil {
 		return err
 	}
-	r.Id = bytes
+	r.Id = id
 	return nil
 }
 
@@ -6,6 +6,14 @@ import (
 	"github.com/juju/errors"
 )
 
+// ResponseId sets the id of the response to an existing json value, typically the id of a request.
+func ResponseId(id json.RawMessage) ResponseOption {
+	return func(opts *ResponseOptions) error {
+		opts.Id = id
+		return nil
+	}
+}
+
 func ResponseStringId(id string) ResponseOption {
 	return func(opts *ResponseOptions) error {
 		bytes, err := json.Marshal(id)
@@ -40,11 +48,13 @@ type ResponseOption = func(opts *ResponseOptions) error
 type ResponseOptions struct {
 	Id      json.RawMessage
 	Version string
+	Codec   Codec
 }
 
 func DefaultResponseOptions() ResponseOptions {
 	return ResponseOptions{
 		Version: "2.0",
+		Codec:   JSONCodec(),
 	}
 }
 
@@ -56,7 +66,7 @@ func NewResponse(result any, options ...ResponseOption) (*Response, error) {
 		}
 	}
 
-	resultBytes, err := json.Marshal(result)
+	resultBytes, err := opts.Codec.Marshal(result)
 	if err != nil {
 		return nil, errors.Annotate(err, "failed to marshal result to json")
 	}
@@ -0,0 +1,258 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/juju/errors"
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// Handler processes a request and returns the result to be sent back. An Error, or an error wrapping one,
+// is returned to the caller as is, any other error is reported as ErrInternal.
+type Handler = func(ctx context.Context, req Request) (any, error)
+
+// Server dispatches requests read from a Connection to the Handler registered for their method.
+type Server struct {
+	handlers sync.Map
+	log      Logger
+	codec    Codec
+}
+
+func ServerLogger(logger Logger) ServerOption {
+	return func(opts *ServerOptions) {
+		opts.Logger = logger
+	}
+}
+
+type ServerOption = func(opts *ServerOptions)
+
+type ServerOptions struct {
+	Logger Logger
+	Codec  Codec
+}
+
+func DefaultServerOptions() ServerOptions {
+	return ServerOptions{
+		Logger: defaultLogger(),
+		Codec:  JSONCodec(),
+	}
+}
+
+func NewServer(options ...ServerOption) *Server {
+	opts := DefaultServerOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if opts.Logger == nil {
+		opts.Logger = NopLogger()
+	}
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec()
+	}
+	return &Server{
+		log:   opts.Logger,
+		codec: opts.Codec,
+	}
+}
+
+func (s *Server) Register(method string, handler Handler) {
+	s.handlers.Store(method, handler)
+}
+
+// Serve reads requests from conn until it is closed or ctx is cancelled, handling each concurrently. The
+// connection is closed when Serve returns.
+func (s *Server) Serve(ctx context.Context, conn Connection) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// unblock the read loop when the context is cancelled
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var writeLock sync.Mutex
+
+	log := s.log.With("connectionId", gonanoid.MustID(12))
+
+	for {
+		data, err := conn.Read()
+		if err != nil {
+			if errors.Is(err, ErrClosed) {
+				return ctx.Err()
+			}
+			log.Error("read failure", "error", err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			reply := s.handleMessage(ctx, data)
+			if pooled, ok := conn.(PooledConnection); ok {
+				pooled.Release(data)
+			}
+			if reply == nil {
+				return
+			}
+
+			writeLock.Lock()
+			defer writeLock.Unlock()
+
+			if err := conn.Write(reply); err != nil {
+				log.Error("write failure", "error", err)
+			}
+		}()
+	}
+}
+
+// handleMessage processes a single request or a batch, returning the marshalled reply or nil if no reply
+// is required.
+func (s *Server) handleMessage(ctx context.Context, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+
+	var reply any
+	if len(data) > 0 && data[0] == '[' {
+		resps := s.handleBatch(ctx, data)
+		if resps == nil {
+			return nil
+		}
+		reply = resps
+	} else {
+		resp := s.handleRequest(ctx, data)
+		if resp == nil {
+			return nil
+		}
+		reply = resp
+	}
+
+	bytes, err := s.codec.Marshal(reply)
+	if err != nil {
+		s.log.Error("failed to marshal response", "error", err)
+		return nil
+	}
+	return bytes
+}
+
+func (s *Server) handleBatch(ctx context.Context, data []byte) any {
+	var elements []json.RawMessage
+	if err := s.codec.Unmarshal(data, &elements); err != nil {
+		return s.errorResponse(ErrParse, nil)
+	}
+
+	if len(elements) == 0 {
+		return s.errorResponse(ErrInvalidRequest, nil)
+	}
+
+	resps := make([]*Response, len(elements))
+
+	var wg sync.WaitGroup
+	wg.Add(len(elements))
+	for i := range elements {
+		go func(i int) {
+			defer wg.Done()
+			resps[i] = s.handleRequest(ctx, elements[i])
+		}(i)
+	}
+	wg.Wait()
+
+	// notifications do not receive a response
+	var result []*Response
+	for _, resp := range resps {
+		if resp != nil {
+			result = append(result, resp)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func (s *Server) handleRequest(ctx context.Context, data []byte) *Response {
+	if !json.Valid(data) {
+		return s.errorResponse(ErrParse, nil)
+	}
+
+	var req Request
+	if err := s.codec.Unmarshal(data, &req); err != nil || req.Method == "" {
+		return s.errorResponse(ErrInvalidRequest, req.Id)
+	}
+
+	isNotification := len(req.Id) == 0
+
+	value, ok := s.handlers.Load(req.Method)
+	if !ok {
+		if isNotification {
+			return nil
+		}
+		return s.errorResponse(ErrMethodNotFound, req.Id)
+	}
+
+	result, err := s.invoke(ctx, value.(Handler), req)
+
+	if isNotification {
+		return nil
+	}
+
+	if err != nil {
+		return s.errorResponse(toError(err), req.Id)
+	}
+
+	resp, err := NewResponse(result, ResponseId(req.Id), ResponseCodec(s.codec))
+	if err != nil {
+		s.log.Error("failed to create response", "error", err, "method", req.Method, "id", string(req.Id))
+		return s.errorResponse(ErrInternal, req.Id)
+	}
+	return resp
+}
+
+func (s *Server) invoke(ctx context.Context, handler Handler, req Request) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.log.Error("handler panic", "panic", r, "method", req.Method, "id", string(req.Id))
+			result, err = nil, ErrInternal
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func (s *Server) errorResponse(e Error, id json.RawMessage) *Response {
+	if len(id) == 0 {
+		// the spec requires a null id when it could not be determined
+		id = json.RawMessage("null")
+	}
+	resp, _ := NewResponseError(e, ResponseId(id))
+	return resp
+}
+
+// toError converts err into an Error, falling back to ErrInternal with the error message as data.
+func toError(err error) Error {
+	var ptr *Error
+	if errors.As(err, &ptr) && ptr != nil {
+		return *ptr
+	}
+
+	var e Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	return withErrorData(ErrInternal, err)
+}
+
+// withErrorData returns a copy of e with the message of err included as data.
+func withErrorData(e Error, err error) Error {
+	if data, marshalErr := json.Marshal(err.Error()); marshalErr == nil {
+		e.Data = data
+	}
+	return e
+}
@@ -0,0 +1,164 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer() *jsonrpc.Server {
+	srv := jsonrpc.NewServer()
+	srv.Register("echo", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		var params []string
+		if err := req.UnmarshalParams(&params); err != nil {
+			return nil, jsonrpc.ErrInvalidParams
+		}
+		return params, nil
+	})
+	srv.Register("fail", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		return nil, errors.New("something went wrong")
+	})
+	srv.Register("panic", func(ctx context.Context, req jsonrpc.Request) (any, error) {
+		panic("boom")
+	})
+	return srv
+}
+
+// serveWs exposes srv over a websocket endpoint and returns a raw connection to it.
+func serveWs(t *testing.T, srv *jsonrpc.Server) *websocket.Conn {
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = srv.Serve(r.Context(), jsonrpc.NewWebSocketConnection(c))
+	}))
+	t.Cleanup(httpSrv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl(httpSrv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+var serverTestCases = []struct {
+	name     string
+	request  string
+	response string
+}{
+	{
+		"result",
+		`{"jsonrpc":"2.0","id":1,"method":"echo","params":["hello"]}`,
+		`{"id":1,"result":["hello"],"jsonrpc":"2.0"}`,
+	},
+	{
+		"method not found",
+		`{"jsonrpc":"2.0","id":"a","method":"missing"}`,
+		`{"id":"a","error":{"code":-32601,"message":"method not found"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"invalid params",
+		`{"jsonrpc":"2.0","id":2,"method":"echo","params":{"foo":"bar"}}`,
+		`{"id":2,"error":{"code":-32602,"message":"invalid params"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"internal error",
+		`{"jsonrpc":"2.0","id":3,"method":"fail"}`,
+		`{"id":3,"error":{"code":-32603,"message":"internal error","data":"something went wrong"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"handler panic",
+		`{"jsonrpc":"2.0","id":4,"method":"panic"}`,
+		`{"id":4,"error":{"code":-32603,"message":"internal error"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"parse error",
+		`{"jsonrpc":"2.0","method":"echo`,
+		`{"id":null,"error":{"code":-32700,"message":"parse error"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"invalid request",
+		`{"jsonrpc":"2.0","id":5,"params":[]}`,
+		`{"id":5,"error":{"code":-32600,"message":"invalid request"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"empty batch",
+		`[]`,
+		`{"id":null,"error":{"code":-32600,"message":"invalid request"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"batch",
+		`[{"jsonrpc":"2.0","id":1,"method":"echo","params":["a"]},{"jsonrpc":"2.0","method":"echo"},{"jsonrpc":"2.0","id":2,"method":"missing"}]`,
+		`[{"id":1,"result":["a"],"jsonrpc":"2.0"},{"id":2,"error":{"code":-32601,"message":"method not found"},"jsonrpc":"2.0"}]`,
+	},
+}
+
+func TestServer_Serve(t *testing.T) {
+	conn := serveWs(t, newTestServer())
+
+	for _, tc := range serverTestCases {
+		err := conn.WriteMessage(websocket.TextMessage, []byte(tc.request))
+		assert.Nil(t, err, tc.name)
+
+		_, msg, err := conn.ReadMessage()
+		assert.Nil(t, err, tc.name)
+		assert.Equal(t, tc.response, string(msg), tc.name)
+	}
+}
+
+func TestServer_Notifications(t *testing.T) {
+	conn := serveWs(t, newTestServer())
+
+	// neither notifications nor batches consisting only of notifications receive a response
+	requests := []string{
+		`{"jsonrpc":"2.0","method":"echo","params":["a"]}`,
+		`{"jsonrpc":"2.0","method":"missing"}`,
+		`[{"jsonrpc":"2.0","method":"echo"},{"jsonrpc":"2.0","method":"fail"}]`,
+		`{"jsonrpc":"2.0","id":1,"method":"echo","params":["done"]}`,
+	}
+
+	for _, req := range requests {
+		err := conn.WriteMessage(websocket.TextMessage, []byte(req))
+		assert.Nil(t, err)
+	}
+
+	_, msg, err := conn.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":1,"result":["done"],"jsonrpc":"2.0"}`, string(msg))
+}
+
+func TestServer_Client(t *testing.T) {
+	srv := newTestServer()
+
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = srv.Serve(r.Context(), jsonrpc.NewWebSocketConnection(c))
+	}))
+	defer httpSrv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(httpSrv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	var resp jsonrpc.Response
+	err = client.Send(*newRequest("echo", []string{"hello", "world"}), &resp)
+	assert.Nil(t, err)
+
+	var result []string
+	err = resp.UnmarshalResult(&result)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hello", "world"}, result)
+}
@@ -0,0 +1,177 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/juju/errors"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterService exposes the suitable exported methods of receiver as `name_methodName`, with the first
+// letter of the method name lower-cased. A method is suitable if it returns at most one value plus an
+// optional trailing error. A leading context.Context argument receives the request context.
+//
+// Params may be provided positionally as an array, with trailing pointer, slice, map or interface arguments
+// being optional, or as an object when the method accepts a single argument.
+func (s *Server) RegisterService(name string, receiver any) error {
+	rcvr := reflect.ValueOf(receiver)
+	typ := rcvr.Type()
+
+	callbacks := make(map[string]*callback)
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+		if !method.IsExported() {
+			continue
+		}
+		cb, ok := newCallback(rcvr.Method(i), s.codec)
+		if !ok {
+			continue
+		}
+		callbacks[name+"_"+formatMethodName(method.Name)] = cb
+	}
+
+	if len(callbacks) == 0 {
+		return errors.Errorf("service %s has no suitable methods", name)
+	}
+
+	for method, cb := range callbacks {
+		s.Register(method, cb.handle)
+	}
+	return nil
+}
+
+func formatMethodName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
+
+// callback is a method which has been adapted into a Handler.
+type callback struct {
+	fn         reflect.Value
+	codec      Codec
+	hasContext bool
+	argTypes   []reflect.Type
+	hasResult  bool
+	hasError   bool
+}
+
+func newCallback(fn reflect.Value, codec Codec) (*callback, bool) {
+	fnType := fn.Type()
+	cb := &callback{fn: fn, codec: codec}
+
+	firstArg := 0
+	if fnType.NumIn() > 0 && fnType.In(0) == contextType {
+		cb.hasContext = true
+		firstArg = 1
+	}
+	for i := firstArg; i < fnType.NumIn(); i++ {
+		cb.argTypes = append(cb.argTypes, fnType.In(i))
+	}
+
+	switch fnType.NumOut() {
+	case 0:
+	case 1:
+		if fnType.Out(0) == errorType {
+			cb.hasError = true
+		} else {
+			cb.hasResult = true
+		}
+	case 2:
+		if fnType.Out(0) == errorType || fnType.Out(1) != errorType {
+			return nil, false
+		}
+		cb.hasResult = true
+		cb.hasError = true
+	default:
+		return nil, false
+	}
+
+	return cb, true
+}
+
+func (cb *callback) handle(ctx context.Context, req Request) (any, error) {
+	args, err := cb.parseParams(req.Params)
+	if err != nil {
+		return nil, withErrorData(ErrInvalidParams, err)
+	}
+
+	var in []reflect.Value
+	if cb.hasContext {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	in = append(in, args...)
+
+	out := cb.fn.Call(in)
+
+	if cb.hasError {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return nil, err
+		}
+	}
+
+	if cb.hasResult {
+		return out[0].Interface(), nil
+	}
+	return nil, nil
+}
+
+func (cb *callback) parseParams(params json.RawMessage) ([]reflect.Value, error) {
+	params = bytes.TrimSpace(params)
+
+	var raw []json.RawMessage
+	switch {
+	case len(params) == 0 || bytes.Equal(params, []byte("null")):
+	case params[0] == '[':
+		if err := cb.codec.Unmarshal(params, &raw); err != nil {
+			return nil, err
+		}
+	case params[0] == '{':
+		if len(cb.argTypes) != 1 {
+			return nil, errors.New("named params are only supported for methods with a single argument")
+		}
+		raw = []json.RawMessage{params}
+	default:
+		return nil, errors.New("params must be an array or an object")
+	}
+
+	if len(raw) > len(cb.argTypes) {
+		return nil, errors.Errorf("too many arguments, want at most %d", len(cb.argTypes))
+	}
+
+	args := make([]reflect.Value, len(cb.argTypes))
+	for i, argType := range cb.argTypes {
+		if i >= len(raw) {
+			if !isOptional(argType) {
+				return nil, errors.Errorf("missing value for required argument %d", i)
+			}
+			args[i] = reflect.Zero(argType)
+			continue
+		}
+
+		arg := reflect.New(argType)
+		if err := cb.codec.Unmarshal(raw[i], arg.Interface()); err != nil {
+			return nil, errors.Annotatef(err, "invalid argument %d", i)
+		}
+		args[i] = arg.Elem()
+	}
+
+	return args, nil
+}
+
+func isOptional(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,135 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+type greeting struct {
+	Name     string `json:"name"`
+	Greeting string `json:"greeting"`
+}
+
+type testService struct{}
+
+func (s *testService) Add(a, b int) int {
+	return a + b
+}
+
+func (s *testService) Echo(ctx context.Context, msg string) (string, error) {
+	return msg, ctx.Err()
+}
+
+func (s *testService) Fail() error {
+	return errors.New("failed")
+}
+
+func (s *testService) Reject() (string, error) {
+	return "", jsonrpc.Error{Code: -32000, Message: "rejected"}
+}
+
+func (s *testService) Greet(g greeting) string {
+	return g.Greeting + " " + g.Name
+}
+
+func (s *testService) Increment(a int, b *int) int {
+	if b == nil {
+		return a + 1
+	}
+	return a + *b
+}
+
+func (s *testService) Unsuitable() (int, int) {
+	return 0, 0
+}
+
+var serviceTestCases = []struct {
+	name     string
+	request  string
+	response string
+}{
+	{
+		"positional",
+		`{"jsonrpc":"2.0","id":1,"method":"test_add","params":[1,2]}`,
+		`{"id":1,"result":3,"jsonrpc":"2.0"}`,
+	},
+	{
+		"context",
+		`{"jsonrpc":"2.0","id":2,"method":"test_echo","params":["hello"]}`,
+		`{"id":2,"result":"hello","jsonrpc":"2.0"}`,
+	},
+	{
+		"error",
+		`{"jsonrpc":"2.0","id":3,"method":"test_fail"}`,
+		`{"id":3,"error":{"code":-32603,"message":"internal error","data":"failed"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"custom error",
+		`{"jsonrpc":"2.0","id":4,"method":"test_reject"}`,
+		`{"id":4,"error":{"code":-32000,"message":"rejected"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"named",
+		`{"jsonrpc":"2.0","id":5,"method":"test_greet","params":{"name":"world","greeting":"hello"}}`,
+		`{"id":5,"result":"hello world","jsonrpc":"2.0"}`,
+	},
+	{
+		"optional argument omitted",
+		`{"jsonrpc":"2.0","id":6,"method":"test_increment","params":[1]}`,
+		`{"id":6,"result":2,"jsonrpc":"2.0"}`,
+	},
+	{
+		"optional argument provided",
+		`{"jsonrpc":"2.0","id":7,"method":"test_increment","params":[1,5]}`,
+		`{"id":7,"result":6,"jsonrpc":"2.0"}`,
+	},
+	{
+		"missing argument",
+		`{"jsonrpc":"2.0","id":8,"method":"test_add","params":[1]}`,
+		`{"id":8,"error":{"code":-32602,"message":"invalid params","data":"missing value for required argument 1"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"too many arguments",
+		`{"jsonrpc":"2.0","id":9,"method":"test_add","params":[1,2,3]}`,
+		`{"id":9,"error":{"code":-32602,"message":"invalid params","data":"too many arguments, want at most 2"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"wrong type",
+		`{"jsonrpc":"2.0","id":10,"method":"test_add","params":["1",2]}`,
+		`{"id":10,"error":{"code":-32602,"message":"invalid params","data":"invalid argument 0: json: cannot unmarshal string into Go value of type int"},"jsonrpc":"2.0"}`,
+	},
+	{
+		"unsuitable method",
+		`{"jsonrpc":"2.0","id":11,"method":"test_unsuitable"}`,
+		`{"id":11,"error":{"code":-32601,"message":"method not found"},"jsonrpc":"2.0"}`,
+	},
+}
+
+func TestServer_RegisterService(t *testing.T) {
+	srv := jsonrpc.NewServer()
+	err := srv.RegisterService("test", &testService{})
+	assert.Nil(t, err)
+
+	conn := serveWs(t, srv)
+
+	for _, tc := range serviceTestCases {
+		err := conn.WriteMessage(websocket.TextMessage, []byte(tc.request))
+		assert.Nil(t, err, tc.name)
+
+		_, msg, err := conn.ReadMessage()
+		assert.Nil(t, err, tc.name)
+		assert.Equal(t, tc.response, string(msg), tc.name)
+	}
+}
+
+func TestServer_RegisterServiceNoMethods(t *testing.T) {
+	srv := jsonrpc.NewServer()
+	err := srv.RegisterService("test", struct{}{})
+	assert.Error(t, err)
+}
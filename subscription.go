@@ -0,0 +1,289 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+var ErrSubscriptionOverflow = errors.ConstError("subscription buffer overflow")
+
+// Subscription represents a stream of notifications pushed by the server following a call to a
+// `*_subscribe` method such as `eth_subscribe`.
+type Subscription interface {
+	// Id returns the subscription id assigned by the server.
+	Id() string
+
+	// Notifications returns a channel which receives the result of each notification. It is closed when the
+	// subscription ends.
+	Notifications() <-chan json.RawMessage
+
+	// Err returns a channel which receives the reason the subscription ended, if any, before being closed.
+	Err() <-chan error
+
+	// Unsubscribe calls the corresponding `*_unsubscribe` method and ends the subscription. The subscription
+	// ends straight away, whilst the server is given a second to respond before an error is returned.
+	Unsubscribe() error
+}
+
+type subscription struct {
+	client            *client
+	id                string
+	unsubscribeMethod string
+	notifications     chan json.RawMessage
+	err               chan error
+	lock              sync.Mutex
+	ended             bool
+}
+
+func (s *subscription) Id() string {
+	return s.id
+}
+
+func (s *subscription) Notifications() <-chan json.RawMessage {
+	return s.notifications
+}
+
+func (s *subscription) Err() <-chan error {
+	return s.err
+}
+
+func (s *subscription) Unsubscribe() error {
+	if !s.end(nil) {
+		return nil
+	}
+
+	req, err := s.unsubscribeRequest()
+	if err != nil {
+		return err
+	}
+
+	// a peer which has stopped responding must not block the caller indefinitely
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	var resp Response
+	if err := s.client.SendContext(ctx, *req, &resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	return nil
+}
+
+func (s *subscription) unsubscribeRequest() (*Request, error) {
+	return NewRequest(s.unsubscribeMethod, []string{s.id}, RequestCodec(s.client.opts.Codec))
+}
+
+// deliver passes a notification result to the subscriber, ending the subscription if the buffer is full.
+func (s *subscription) deliver(result json.RawMessage) {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	select {
+	case s.notifications <- result:
+		s.lock.Unlock()
+		return
+	default:
+		s.lock.Unlock()
+	}
+
+	s.client.logger().Warn("subscription buffer is full, unsubscribing", "subscription", s.id)
+
+	if s.end(ErrSubscriptionOverflow) {
+		s.client.notifyUnsubscribe(s)
+	}
+}
+
+// end closes the subscription channels, returning false if it had already ended.
+func (s *subscription) end(err error) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.endLocked(err)
+}
+
+// abandon ends a subscription whose subscribe request failed, returning true if the read loop had already
+// registered it, in which case the server must be told to unsubscribe.
+func (s *subscription) abandon(err error) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.endLocked(err)
+	return s.id != ""
+}
+
+func (s *subscription) endLocked(err error) bool {
+	if s.ended {
+		return false
+	}
+	s.ended = true
+
+	s.client.subscriptions.Delete(s.id)
+
+	if err != nil {
+		s.err <- err
+	}
+	close(s.err)
+	close(s.notifications)
+
+	return true
+}
+
+// subscriptionParams is the params object of a `*_subscription` notification.
+type subscriptionParams struct {
+	Subscription json.RawMessage `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
+
+func subscriptionId(codec Codec, raw json.RawMessage) string {
+	if id, ok := plainString(raw); ok {
+		return id
+	}
+	var id string
+	if err := codec.Unmarshal(raw, &id); err != nil {
+		return string(raw)
+	}
+	return id
+}
+
+func (c *client) Subscribe(ctx context.Context, method string, params any) (Subscription, error) {
+	if !strings.HasSuffix(method, "_subscribe") {
+		return nil, errors.Errorf("subscription method %s must have a _subscribe suffix", method)
+	}
+
+	req, err := NewRequest(method, params, RequestCodec(c.opts.Codec))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.EnsureIdWith(c.opts.IdStrategy); err != nil {
+		return nil, err
+	}
+
+	sub := &subscription{
+		client:            c,
+		unsubscribeMethod: strings.TrimSuffix(method, "subscribe") + "unsubscribe",
+		notifications:     make(chan json.RawMessage, c.opts.SubscriptionBuffer),
+		err:               make(chan error, 1),
+	}
+
+	// the subscription is registered by the read loop as soon as the response arrives, ensuring that
+	// notifications which immediately follow it are not missed
+	key := canonicalId(c.opts.Codec, req.Id)
+	c.pendingSubscriptions.Store(key, sub)
+	defer c.pendingSubscriptions.Delete(key)
+
+	var resp Response
+	if err := c.SendContext(ctx, *req, &resp); err != nil {
+		// the response may still have arrived, for example when the context ended just as it was read
+		if sub.abandon(err) {
+			c.notifyUnsubscribe(sub)
+		}
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	if sub.id == "" {
+		return nil, errors.New("subscription id missing from response")
+	}
+
+	return sub, nil
+}
+
+func (c *client) onSubscribed(sub *subscription, resp *Response) {
+	if resp.Error != nil || len(resp.Result) == 0 {
+		return
+	}
+
+	sub.lock.Lock()
+	sub.id = subscriptionId(c.opts.Codec, resp.Result)
+	abandoned := sub.ended
+	if !abandoned {
+		c.subscriptions.Store(sub.id, sub)
+	}
+	sub.lock.Unlock()
+
+	// Subscribe has already given up on the subscription, so it is not wanted
+	if abandoned {
+		c.notifyUnsubscribe(sub)
+	}
+}
+
+// onNotification routes a `*_subscription` notification to its subscription, returning false if there
+// is no matching subscription.
+func (c *client) onNotification(req Request) bool {
+	if !strings.HasSuffix(req.Method, "_subscription") {
+		return false
+	}
+
+	var params subscriptionParams
+	if err := c.opts.Codec.Unmarshal(req.Params, &params); err != nil {
+		return false
+	}
+
+	value, ok := c.subscriptions.Load(subscriptionId(c.opts.Codec, params.Subscription))
+	if !ok {
+		return false
+	}
+
+	value.(*subscription).deliver(params.Result)
+	return true
+}
+
+// notifyUnsubscribe sends the unsubscribe request without waiting for a response.
+func (c *client) notifyUnsubscribe(sub *subscription) {
+	req, err := sub.unsubscribeRequest()
+	if err != nil {
+		c.logger().Error("failed to create unsubscribe request", "error", err, "subscription", sub.id)
+		return
+	}
+
+	c.SendAsync(*req)
+}
+
+func (c *client) endSubscriptions(err error) {
+	c.subscriptions.Range(func(key, value any) bool {
+		value.(*subscription).end(err)
+		return true
+	})
+}
+
+// unsubscribeAll writes an unsubscribe request for every subscription without registering an in flight
+// entry, as the client is closing and will not be around to receive the response.
+func (c *client) unsubscribeAll() {
+	c.connLock.RLock()
+	conn := c.conn
+	c.connLock.RUnlock()
+
+	if conn == nil {
+		return
+	}
+
+	c.subscriptions.Range(func(key, value any) bool {
+		sub := value.(*subscription)
+
+		req, err := sub.unsubscribeRequest()
+		if err == nil {
+			err = req.EnsureIdWith(c.opts.IdStrategy)
+		}
+		var bytes []byte
+		if err == nil {
+			bytes, err = c.opts.Codec.Marshal(req)
+		}
+		if err == nil {
+			err = conn.Write(bytes)
+		}
+		if err != nil {
+			c.logger().Debug("unsubscribe failure", "error", err, "subscription", sub.id)
+		}
+		return true
+	})
+}
@@ -0,0 +1,235 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/41north/jsonrpc.go"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSubscriptionNotification(id string, result any) *jsonrpc.Request {
+	return newRequest("eth_subscription", map[string]any{"subscription": id, "result": result})
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	unsubscribed := make(chan jsonrpc.Request, 1)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(jsonrpc.Response{Id: req.Id, Result: json.RawMessage(`"0xabc"`), Version: "2.0"})
+
+		for i := 0; i < 3; i++ {
+			_ = c.WriteJSON(newSubscriptionNotification("0xabc", i))
+		}
+
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		unsubscribed <- req
+		_ = c.WriteJSON(jsonrpc.Response{Id: req.Id, Result: json.RawMessage(`true`), Version: "2.0"})
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	sub, err := client.Subscribe(context.Background(), "eth_subscribe", []string{"newHeads"})
+	assert.Nil(t, err)
+	assert.Equal(t, "0xabc", sub.Id())
+
+	for i := 0; i < 3; i++ {
+		var result int
+		err = json.Unmarshal(<-sub.Notifications(), &result)
+		assert.Nil(t, err)
+		assert.Equal(t, i, result)
+	}
+
+	err = sub.Unsubscribe()
+	assert.Nil(t, err)
+
+	req := <-unsubscribed
+	assert.Equal(t, "eth_unsubscribe", req.Method)
+	assert.Equal(t, json.RawMessage(`["0xabc"]`), req.Params)
+
+	// channels are closed once the subscription has ended
+	_, ok := <-sub.Notifications()
+	assert.False(t, ok)
+	_, ok = <-sub.Err()
+	assert.False(t, ok)
+}
+
+func TestClient_SubscribeError(t *testing.T) {
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(jsonrpc.Response{Id: req.Id, Error: &jsonrpc.ErrInvalidParams, Version: "2.0"})
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	_, err = client.Subscribe(context.Background(), "eth_subscribe", []string{"foo"})
+	assert.Equal(t, jsonrpc.ErrInvalidParams, *err.(*jsonrpc.Error))
+
+	_, err = client.Subscribe(context.Background(), "eth_blockNumber", nil)
+	assert.Error(t, err)
+}
+
+func TestClient_SubscriptionOverflow(t *testing.T) {
+	unsubscribed := make(chan jsonrpc.Request, 1)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(jsonrpc.Response{Id: req.Id, Result: json.RawMessage(`"0x1"`), Version: "2.0"})
+
+		for i := 0; i < 5; i++ {
+			_ = c.WriteJSON(newSubscriptionNotification("0x1", i))
+		}
+
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		unsubscribed <- req
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)}, jsonrpc.ClientSubscriptionBuffer(2))
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	sub, err := client.Subscribe(context.Background(), "eth_subscribe", []string{"newHeads"})
+	assert.Nil(t, err)
+
+	assert.Equal(t, jsonrpc.ErrSubscriptionOverflow, <-sub.Err())
+	assert.Equal(t, "eth_unsubscribe", (<-unsubscribed).Method)
+}
+
+func TestClient_SubscribeFailsAfterRegistration(t *testing.T) {
+	unsubscribed := make(chan jsonrpc.Request, 1)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(jsonrpc.Response{Id: req.Id, Result: json.RawMessage(`"0x1"`), Version: "2.0"})
+
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		unsubscribed <- req
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// the response is read and the subscription registered, but the send still fails
+	client.Use(func(next jsonrpc.Invoker) jsonrpc.Invoker {
+		return func(ctx context.Context, req jsonrpc.Request) (*jsonrpc.Response, error) {
+			if _, err := next(ctx, req); err != nil {
+				return nil, err
+			}
+			return nil, context.DeadlineExceeded
+		}
+	})
+
+	_, err = client.Subscribe(context.Background(), "eth_subscribe", []string{"newHeads"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	select {
+	case req := <-unsubscribed:
+		assert.Equal(t, "eth_unsubscribe", req.Method)
+		assert.Equal(t, json.RawMessage(`["0x1"]`), req.Params)
+	case <-time.After(time.Second):
+		t.Fatal("the registered subscription was not unsubscribed")
+	}
+}
+
+func TestClient_UnsubscribeUnresponsive(t *testing.T) {
+	done := make(chan struct{})
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(jsonrpc.Response{Id: req.Id, Result: json.RawMessage(`"0x1"`), Version: "2.0"})
+
+		// the unsubscribe is read but never answered
+		_ = c.ReadJSON(&req)
+		<-done
+	})
+	defer srv.Close()
+	defer close(done)
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+	defer client.Close()
+
+	sub, err := client.Subscribe(context.Background(), "eth_subscribe", []string{"newHeads"})
+	assert.Nil(t, err)
+
+	unsubscribed := make(chan error, 1)
+	go func() { unsubscribed <- sub.Unsubscribe() }()
+
+	select {
+	case err := <-unsubscribed:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(3 * time.Second):
+		t.Fatal("unsubscribe blocked on the unresponsive peer")
+	}
+
+	// the subscription has ended regardless
+	_, ok := <-sub.Notifications()
+	assert.False(t, ok)
+}
+
+func TestClient_SubscriptionClose(t *testing.T) {
+	unsubscribed := make(chan jsonrpc.Request, 1)
+
+	srv := newScriptedWsServer(func(c *websocket.Conn) {
+		var req jsonrpc.Request
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = c.WriteJSON(jsonrpc.Response{Id: req.Id, Result: json.RawMessage(`"0x2"`), Version: "2.0"})
+
+		if err := c.ReadJSON(&req); err != nil {
+			return
+		}
+		unsubscribed <- req
+	})
+	defer srv.Close()
+
+	client := jsonrpc.NewClient(jsonrpc.WebSocketDialer{Url: wsUrl(srv)})
+	err := client.Connect()
+	assert.Nil(t, err)
+
+	sub, err := client.Subscribe(context.Background(), "eth_subscribe", []string{"newHeads"})
+	assert.Nil(t, err)
+
+	err = client.Close()
+	assert.Nil(t, err)
+
+	assert.Equal(t, jsonrpc.ErrClosed, <-sub.Err())
+	assert.Equal(t, "eth_unsubscribe", (<-unsubscribed).Method)
+}